@@ -0,0 +1,171 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Report is the result of a static analysis of a machine
+type Report struct {
+	// Unreachable holds states, other than end states, that cannot be
+	// reached from the start state of any region
+	Unreachable []State
+	// DeadEnds holds leaf states that are not end states and have no
+	// outgoing transitions, either directly or through an enclosing state.
+	// A machine entering one of these can never leave it.
+	DeadEnds []State
+	// UnreachableEnds holds end states that cannot be reached from the
+	// start state
+	UnreachableEnds []State
+	// Undeclared holds transitions to or from a state that was not declared
+	// with WithStates or AddState.  It is only populated when the machine
+	// declares its states.
+	Undeclared []Transition
+	// Conflicts holds states that can be reached from more than one region
+	Conflicts []State
+}
+
+// Err returns an error describing every problem in the report that makes
+// a machine invalid, or nil if there are none.  Dead ends are not treated as
+// errors, since machines without end states may legitimately terminate in
+// a state with no outgoing transitions.
+func (r Report) Err() error {
+	var problems []string
+	for _, s := range r.Unreachable {
+		problems = append(problems, fmt.Sprintf("state '%s' is unreachable", s.Name()))
+	}
+	for _, s := range r.UnreachableEnds {
+		problems = append(problems, fmt.Sprintf("end state '%s' is unreachable", s.Name()))
+	}
+	for _, s := range r.Conflicts {
+		problems = append(problems, fmt.Sprintf("state '%s' is shared between regions", s.Name()))
+	}
+	for _, t := range r.Undeclared {
+		problems = append(problems, fmt.Sprintf("transition '%s' from '%s' to '%s' uses an undeclared state", t.Description(), t.From().Name(), t.To().Name()))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("invalid: %s", strings.Join(problems, "; "))
+}
+
+// Analyze inspects the machine for unreachable states, dead ends,
+// unreachable end states, transitions using undeclared states and states
+// shared between regions
+func (m *machine) Analyze() Report {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.analyze()
+}
+
+// analyze is the lock-free implementation of Analyze.  The caller must hold
+// m.mu.
+func (m *machine) analyze() Report {
+	var r Report
+
+	reachable := make(map[uint64]bool)
+	owner := make(map[uint64]int)
+	conflicts := make(map[uint64]bool)
+	for i, root := range m.roots() {
+		for id := range m.reachable(root) {
+			if prev, ok := owner[id]; ok && prev != i {
+				conflicts[id] = true
+			}
+			owner[id] = i
+			reachable[id] = true
+		}
+	}
+
+	for _, s := range m.states() {
+		_, end := m.endStates[s.Id()]
+		switch {
+		case !reachable[s.Id()] && end:
+			r.UnreachableEnds = append(r.UnreachableEnds, s)
+		case !reachable[s.Id()]:
+			r.Unreachable = append(r.Unreachable, s)
+		}
+		if m.isDeadEnd(s) {
+			r.DeadEnds = append(r.DeadEnds, s)
+		}
+		if conflicts[s.Id()] {
+			r.Conflicts = append(r.Conflicts, s)
+		}
+
+		if len(m.declared) == 0 {
+			continue
+		}
+		for _, t := range m.transitions[s.Id()] {
+			if !m.isDeclared(t.From()) || !m.isDeclared(anchor(t.To())) {
+				r.Undeclared = append(r.Undeclared, t)
+			}
+		}
+	}
+
+	return r
+}
+
+// reachable returns the set of states that can be reached from root.  The
+// caller must hold m.mu.
+func (m *machine) reachable(root State) map[uint64]bool {
+	seen := make(map[uint64]bool)
+	var queue []State
+	mark := func(s State) {
+		if !seen[s.Id()] {
+			seen[s.Id()] = true
+			queue = append(queue, s)
+		}
+	}
+	// entering a state also activates its enclosing states and its initial
+	// substates
+	enter := func(s State) {
+		for _, a := range m.ancestors(s) {
+			mark(a)
+		}
+		for children := m.children[s.Id()]; len(children) > 0; children = m.children[s.Id()] {
+			s = children[0]
+			mark(s)
+		}
+	}
+
+	enter(root)
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, t := range m.transitions[s.Id()] {
+			if to := t.To(); to != nil {
+				enter(anchor(to))
+			}
+		}
+	}
+
+	return seen
+}
+
+// isDeadEnd reports whether s is a leaf state that is not an end state and
+// has no way out, either directly or through an enclosing state.  The caller
+// must hold m.mu.
+func (m *machine) isDeadEnd(s State) bool {
+	if len(m.children[s.Id()]) > 0 {
+		return false
+	}
+	for _, a := range m.ancestors(s) {
+		if _, end := m.endStates[a.Id()]; end {
+			return false
+		}
+		if len(m.transitions[a.Id()]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *machine) isDeclared(s State) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := m.declared[s.Id()]
+	return ok
+}
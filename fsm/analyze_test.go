@@ -0,0 +1,81 @@
+package fsm
+
+import (
+	"testing"
+)
+
+func names(states []State) []string {
+	out := make([]string, 0, len(states))
+	for _, s := range states {
+		out = append(out, s.Name())
+	}
+	return out
+}
+
+func TestAnalyze(t *testing.T) {
+	t.Run("clean", func(t *testing.T) {
+		m := recognizer(t)
+		r := m.Analyze()
+		if len(r.Unreachable)+len(r.DeadEnds)+len(r.UnreachableEnds)+len(r.Undeclared) != 0 {
+			t.Fatalf("unexpected findings: %+v", r)
+		}
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("findings", func(t *testing.T) {
+		var (
+			start  = NewState("start")
+			stuck  = NewState("stuck")
+			orphan = NewState("orphan")
+			island = NewState("island")
+			done   = NewState("done")
+			stray  = NewState("stray")
+		)
+
+		m := NewMachine(
+			WithStates(start, stuck, orphan, island, done),
+			WithTransitions(
+				start.When("x", byteIs('x')).Then(stuck),
+				start.When("y", byteIs('y')).Then(stray),
+				orphan.When("z", byteIs('z')).Then(island),
+				island.When("z", byteIs('z')).Then(done),
+			),
+		)
+		if err := m.SetEndStates("done"); err != nil {
+			t.Fatal(err)
+		}
+
+		r := m.Analyze()
+		if got := names(r.Unreachable); len(got) != 2 || got[0] != "island" || got[1] != "orphan" {
+			t.Fatalf("unexpected unreachable states: %v", got)
+		}
+		if got := names(r.DeadEnds); len(got) != 2 || got[0] != "stuck" || got[1] != "stray" {
+			t.Fatalf("unexpected dead ends: %v", got)
+		}
+		if got := names(r.UnreachableEnds); len(got) != 1 || got[0] != "done" {
+			t.Fatalf("unexpected unreachable end states: %v", got)
+		}
+		if len(r.Undeclared) != 1 || r.Undeclared[0].To().Name() != "stray" {
+			t.Fatalf("unexpected undeclared transitions: %v", r.Undeclared)
+		}
+
+		if err := m.Validate(); err == nil {
+			t.Fatal("expected invalid machine")
+		}
+	})
+
+	t.Run("dead ends are not errors", func(t *testing.T) {
+		s1 := NewState("s1")
+		s2 := NewState("s2")
+		m := NewMachine(WithTransitions(s1.When("x", byteIs('x')).Then(s2)))
+
+		if got := names(m.Analyze().DeadEnds); len(got) != 1 || got[0] != "s2" {
+			t.Fatalf("unexpected dead ends: %v", got)
+		}
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
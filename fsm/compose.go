@@ -0,0 +1,331 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// rejected names the part of a pair state for a machine that has rejected a
+// value, in the machines built by Union
+const rejected = "∅"
+
+// operand is a machine taken apart for composition: its transitions are
+// listed in the order Update evaluates them, from the highest priority to the
+// lowest and symbol transitions first
+type operand struct {
+	start       State
+	transitions map[uint64][]Transition
+	final       map[uint64]bool
+}
+
+// operand checks that m can be composed and takes it apart
+func (m *machine) operand() (*operand, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.start == nil {
+		return nil, ErrNoStartState
+	}
+	if m.nondeterministic {
+		return nil, errors.New("cannot compose an NFA machine; determinize it first")
+	}
+	if len(m.children) > 0 || len(m.regions) > 0 {
+		return nil, errors.New("cannot compose a machine with substates or regions")
+	}
+
+	op := &operand{start: m.start, transitions: make(map[uint64][]Transition), final: make(map[uint64]bool)}
+	for _, s := range m.states() {
+		tt := append([]Transition(nil), m.transitions[s.Id()]...)
+		for _, t := range tt {
+			if timeoutOf(t) > 0 || isEpsilon(t) {
+				return nil, fmt.Errorf("cannot compose transition '%s' from '%s'", t.Description(), s.Name())
+			}
+		}
+		sort.SliceStable(tt, func(i, j int) bool {
+			if pi, pj := priorityOf(tt[i]), priorityOf(tt[j]); pi != pj {
+				return pi > pj
+			}
+			_, keyed := symbolOf(tt[i])
+			_, alsoKeyed := symbolOf(tt[j])
+			return keyed && !alsoKeyed
+		})
+		op.transitions[s.Id()] = tt
+		op.final[s.Id()] = m.isFinal(s)
+	}
+	return op, nil
+}
+
+// from returns the transitions out of s, which is nil for a machine that has
+// rejected a value
+func (op *operand) from(s State) []Transition {
+	if s == nil {
+		return nil
+	}
+	return op.transitions[s.Id()]
+}
+
+func (op *operand) isFinal(s State) bool {
+	return s != nil && op.final[s.Id()]
+}
+
+// Product returns the synchronous product of a and b: a machine whose states
+// are the pairs of states a and b can be in together, named like (p,q).  It
+// takes a value only if both a and b take it, along the pair of transitions
+// they take, and is in an end state when both are.  A pair state in which a
+// and b accept no value in common is a dead end, which Analyze reports.
+// Transitions on the same symbol in both machines become symbol
+// transitions; the others evaluate the trigger functions of both.  Since
+// trigger functions cannot be inspected, any two of them are assumed to
+// accept some value in common, so a product of machines using them may have
+// pair states that are never reached at run time.  The transitions out of a
+// pair state are given decreasing priorities, so that they are evaluated in
+// the order a and b evaluate theirs.  The actions of both transitions run,
+// a's first; state actions, interceptors and other settings are not carried
+// over.  a and b must be deterministic, with start states and without
+// substates, regions or timed transitions.
+func Product(a, b *machine) (*machine, error) {
+	return compose(a, b, false)
+}
+
+// Intersection returns a machine that runs a and b in lockstep and accepts
+// the sequences both of them accept.  It is the product of a and b without
+// the pair states from which no end state can be reached.  See Product.
+func Intersection(a, b *machine) (*machine, error) {
+	m, err := compose(a, b, false)
+	if err != nil {
+		return nil, err
+	}
+	m.trim()
+	return m, nil
+}
+
+// Union returns a machine that runs a and b in lockstep and accepts the
+// sequences either of them accepts.  Once one of them rejects a value, the
+// other carries on alone, in pair states named like (p,∅).  Pair states from
+// which no end state can be reached are dropped.  See Product.
+func Union(a, b *machine) (*machine, error) {
+	m, err := compose(a, b, true)
+	if err != nil {
+		return nil, err
+	}
+	m.trim()
+	return m, nil
+}
+
+// compose builds the product of a and b, and if union is set, the pair
+// states in which one of them has rejected a value
+func compose(a, b *machine, union bool) (*machine, error) {
+	x, err := a.operand()
+	if err != nil {
+		return nil, err
+	}
+	y, err := b.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	out := NewMachine()
+	out.transitions = make(map[uint64][]Transition)
+	out.endStates = make(map[uint64]State)
+
+	type pair struct {
+		p, q  State
+		state State
+	}
+	var queue []pair
+	states := make(map[string]State)
+	visit := func(p, q State) State {
+		name := "(" + partName(p) + "," + partName(q) + ")"
+		s, ok := states[name]
+		if !ok {
+			s = NewState(name)
+			states[name] = s
+			queue = append(queue, pair{p: p, q: q, state: s})
+		}
+		return s
+	}
+
+	out.start = visit(x.start, y.start)
+	for i := 0; i < len(queue); i++ {
+		p, q, from := queue[i].p, queue[i].q, queue[i].state
+		prio := 0
+		add := func(t Transition, nextP, nextQ State) {
+			out.insert(Priority(t, prio).Then(visit(nextP, nextQ)))
+			prio--
+		}
+		if x.isFinal(p) && y.isFinal(q) || union && (x.isFinal(p) || y.isFinal(q)) {
+			out.endStates[from.Id()] = from
+		}
+
+		tps, tqs := x.from(p), y.from(q)
+		switch {
+		case p != nil && q != nil:
+			for _, tp := range tps {
+				for _, tq := range tqs {
+					if t := both(from, tp, tq); t != nil {
+						add(t, tp.To(), tq.To())
+					}
+				}
+				if union {
+					if t := alone(from, tp, tqs); t != nil {
+						add(t, tp.To(), nil)
+					}
+				}
+			}
+			if union {
+				for _, tq := range tqs {
+					if t := alone(from, tq, tps); t != nil {
+						add(t, nil, tq.To())
+					}
+				}
+			}
+		case p != nil:
+			for _, tp := range tps {
+				add(lift(from, tp), tp.To(), nil)
+			}
+		case q != nil:
+			for _, tq := range tqs {
+				add(lift(from, tq), nil, tq.To())
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func partName(s State) string {
+	if s == nil {
+		return rejected
+	}
+	return s.Name()
+}
+
+// both returns the transition out of from taken when one machine takes tp
+// and the other takes tq, or nil if they accept no value in common
+func both(from State, tp, tq Transition) Transition {
+	sp, keyed := symbolOf(tp)
+	sq, alsoKeyed := symbolOf(tq)
+	if keyed && alsoKeyed {
+		if sp != sq {
+			return nil
+		}
+		return Do(On(from, sp), actions(tp, tq))
+	}
+
+	desc := tp.Description()
+	if tq.Description() != desc {
+		desc += " & " + tq.Description()
+	}
+	return Do(from.When(desc, func(ctx context.Context, v interface{}) (bool, error) {
+		ok, err := tp.Go(ctx, v)
+		if !ok || err != nil {
+			return false, err
+		}
+		return tq.Go(ctx, v)
+	}), actions(tp, tq))
+}
+
+// alone returns the transition out of from taken when one machine takes t
+// and the other, whose transitions are others, takes none, or nil if that
+// cannot happen
+func alone(from State, t Transition, others []Transition) Transition {
+	sym, keyed := symbolOf(t)
+	if keyed {
+		static := true
+		for _, other := range others {
+			s, ok := symbolOf(other)
+			if !ok {
+				static = false
+				break
+			}
+			if s == sym {
+				return nil
+			}
+		}
+		if static {
+			return Do(On(from, sym), actions(t))
+		}
+	}
+
+	return Do(from.When(t.Description(), func(ctx context.Context, v interface{}) (bool, error) {
+		ok, err := t.Go(ctx, v)
+		if !ok || err != nil {
+			return false, err
+		}
+		for _, other := range others {
+			also, err := other.Go(ctx, v)
+			if also || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}), actions(t))
+}
+
+// lift returns the transition out of from taken when the one machine still
+// running takes t
+func lift(from State, t Transition) Transition {
+	if sym, ok := symbolOf(t); ok {
+		return Do(On(from, sym), actions(t))
+	}
+	return Do(from.When(t.Description(), t.Go), actions(t))
+}
+
+// actions runs the actions of the transitions in order
+func actions(transitions ...Transition) ActionFunc {
+	return func(ctx context.Context, v interface{}) error {
+		for _, t := range transitions {
+			if err := act(ctx, t, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// trim drops the transitions to states from which no end state can be
+// reached, which leaves those states out of the machine.  It is only used on
+// machines that are not shared yet.
+func (m *machine) trim() {
+	into := make(map[uint64][]State)
+	for _, tt := range m.transitions {
+		for _, t := range tt {
+			into[t.To().Id()] = append(into[t.To().Id()], t.From())
+		}
+	}
+
+	live := make(map[uint64]bool)
+	var queue []State
+	for _, s := range m.endStates {
+		live[s.Id()] = true
+		queue = append(queue, s)
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, from := range into[s.Id()] {
+			if !live[from.Id()] {
+				live[from.Id()] = true
+				queue = append(queue, from)
+			}
+		}
+	}
+
+	for id, tt := range m.transitions {
+		var kept []Transition
+		for _, t := range tt {
+			if live[t.To().Id()] {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			delete(m.transitions, id)
+			delete(m.tables, id)
+			continue
+		}
+		m.transitions[id] = kept
+		m.tables[id] = newTable(kept)
+	}
+}
@@ -0,0 +1,291 @@
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// evenAs accepts strings of a and b with an even number of a
+func evenAs(t *testing.T) *machine {
+	t.Helper()
+
+	even, odd := NewState("even"), NewState("odd")
+	m := NewMachine(WithTransitions(
+		On(even, 'a').Then(odd),
+		On(even, 'b').Then(even),
+		On(odd, 'a').Then(even),
+		On(odd, 'b').Then(odd),
+	))
+	if err := m.SetEndStates("even"); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// endsInB accepts strings of a and b ending in b
+func endsInB(t *testing.T) *machine {
+	t.Helper()
+
+	other, b := NewState("other"), NewState("b")
+	m := NewMachine(WithTransitions(
+		On(other, 'a').Then(other),
+		On(other, 'b').Then(b),
+		On(b, 'a').Then(other),
+		On(b, 'b').Then(b),
+	))
+	if err := m.SetEndStates("b"); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// agrees checks that m accepts exactly the words for which want is true
+func agrees(t *testing.T, m *machine, inputs []string, want func(string) bool) {
+	t.Helper()
+
+	for _, w := range inputs {
+		got, err := m.Accepts(context.Background(), Runes(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want(w) {
+			t.Fatalf("%q: expected %v", w, !got)
+		}
+	}
+}
+
+// accepted runs m over w as bytes
+func accepted(t *testing.T, m *machine, w string) bool {
+	t.Helper()
+
+	ok, err := m.Accepts(context.Background(), Bytes(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestIntersection(t *testing.T) {
+	m, err := Intersection(evenAs(t), endsInB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Current().Name(); got != "(even,other)" {
+		t.Fatalf("unexpected start state: %s", got)
+	}
+	if got := len(m.states()); got != 4 {
+		t.Fatalf("expected 4 states, got %d", got)
+	}
+	agrees(t, m, words(7), func(w string) bool {
+		return strings.Count(w, "a")%2 == 0 && strings.HasSuffix(w, "b")
+	})
+
+	// symbol transitions stay symbolic, so the result can be minimized
+	if _, err := m.Minimize(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("guards", func(t *testing.T) {
+		// a+bc and words without a c: nothing in common
+		start, other := NewState("start"), NewState("other")
+		noC := NewMachine(WithTransitions(
+			start.When("a", byteIs('a')).Then(other),
+			start.When("b", byteIs('b')).Then(other),
+			other.When("a", byteIs('a')).Then(other),
+			other.When("b", byteIs('b')).Then(other),
+		))
+		if err := noC.SetEndStates("start", "other"); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := Intersection(recognizer(t), noC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range []string{"", "abc", "aabc", "ab", "ba"} {
+			if accepted(t, m, w) {
+				t.Fatalf("%q: expected rejection", w)
+			}
+		}
+	})
+}
+
+func TestUnion(t *testing.T) {
+	m, err := Union(evenAs(t), endsInB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	agrees(t, m, words(7), func(w string) bool {
+		return strings.Count(w, "a")%2 == 0 || strings.HasSuffix(w, "b")
+	})
+
+	t.Run("different alphabets", func(t *testing.T) {
+		a, b, c := NewState("a"), NewState("b"), NewState("c")
+		ab := NewMachine(WithTransitions(On(a, 'a').Then(b), On(b, 'b').Then(c)))
+		if err := ab.SetEndStates("c"); err != nil {
+			t.Fatal(err)
+		}
+		x, y := NewState("x"), NewState("y")
+		xs := NewMachine(WithTransitions(On(x, 'x').Then(y), On(y, 'x').Then(y)))
+		if err := xs.SetEndStates("y"); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := Union(ab, xs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := m.Graph(&buf); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"(a,x)", "(b,∅)", "(c,∅)", "(∅,y)"} {
+			if !strings.Contains(buf.String(), want) {
+				t.Fatalf("expected %s in graph:\n%s", want, buf.String())
+			}
+		}
+		agrees(t, m, []string{"", "ab", "x", "xxx", "a", "ax", "abx", "xa"}, func(w string) bool {
+			return w == "ab" || (w != "" && strings.Trim(w, "x") == "")
+		})
+	})
+
+	t.Run("guards", func(t *testing.T) {
+		// a+bc or b+
+		start, bs := NewState("start"), NewState("bs")
+		onlyBs := NewMachine(WithTransitions(
+			start.When("b", byteIs('b')).Then(bs),
+			bs.When("b", byteIs('b')).Then(bs),
+		))
+		if err := onlyBs.SetEndStates("bs"); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := Union(recognizer(t), onlyBs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var inputs []string
+		for _, w := range words(6) {
+			inputs = append(inputs, w, w+"c", strings.ReplaceAll(w, "ab", "abc"))
+		}
+		for _, w := range inputs {
+			want := accepted(t, recognizer(t), w) || accepted(t, onlyBs, w)
+			if got := accepted(t, m, w); got != want {
+				t.Fatalf("%q: expected %v", w, want)
+			}
+		}
+	})
+}
+
+func TestProduct(t *testing.T) {
+	ctx := context.Background()
+
+	// a client and a server that disagree on whether a request can be sent
+	// again before the response arrives
+	var log []string
+	record := func(what string) ActionFunc {
+		return func(context.Context, interface{}) error {
+			log = append(log, what)
+			return nil
+		}
+	}
+	idle, waiting := NewState("idle"), NewState("waiting")
+	client := NewMachine(WithTransitions(
+		Do(On(idle, "request"), record("client sends")).Then(waiting),
+		Do(On(waiting, "response"), record("client receives")).Then(idle),
+		On(waiting, "request").Then(waiting),
+	))
+	ready, busy := NewState("ready"), NewState("busy")
+	server := NewMachine(WithTransitions(
+		Do(On(ready, "request"), record("server receives")).Then(busy),
+		Do(On(busy, "response"), record("server sends")).Then(ready),
+	))
+	if err := client.SetEndStates("idle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetEndStates("ready"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Product(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.states()); got != 2 {
+		t.Fatalf("expected 2 states, got %d", got)
+	}
+
+	if ok, err := m.Update(ctx, "request"); !ok || err != nil {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+	if got := m.Current().Name(); got != "(waiting,busy)" {
+		t.Fatalf("unexpected state: %s", got)
+	}
+	// the server does not accept a second request, so neither does the
+	// product
+	if ok, err := m.Update(ctx, "request"); ok || err != nil {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+	if ok, err := m.Update(ctx, "response"); !ok || err != nil || !m.IsEndState() {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+	if got, want := strings.Join(log, ", "), "client sends, server receives, client receives, server sends"; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	t.Run("deadlocks", func(t *testing.T) {
+		// a server that never responds
+		ready, busy := NewState("ready"), NewState("busy")
+		stuck := NewMachine(WithTransitions(On(ready, "request").Then(busy)))
+		m, err := Product(client, stuck)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(m.Analyze().DeadEnds); len(got) != 1 || got[0] != "(waiting,busy)" {
+			t.Fatalf("unexpected dead ends: %v", got)
+		}
+	})
+
+	t.Run("priorities", func(t *testing.T) {
+		// a's guard outranks its symbol transition, and the product keeps that
+		a, b, c := NewState("a"), NewState("b"), NewState("c")
+		x := NewMachine(WithTransitions(
+			On(a, "request").Then(b),
+			Priority(a.When("anything", func(context.Context, interface{}) (bool, error) {
+				return true, nil
+			}), 1).Then(c),
+		))
+		m, err := Product(x, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := m.Update(ctx, "request"); !ok || err != nil || m.Current().Name() != "(c,busy)" {
+			t.Fatalf("ok=%v err=%v state=%s", ok, err, m.Current().Name())
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		a, b := NewState("a"), NewState("b")
+		for name, other := range map[string]*machine{
+			"no start":  NewMachine(),
+			"nfa":       NewMachine(WithNFA(), WithTransitions(On(a, 1).Then(b))),
+			"timed":     NewMachine(WithTransitions(After(a, 1).Then(b))),
+			"substates": NewMachine(WithTransitions(On(a, 1).Then(b)), WithSubstates(b, NewState("c"))),
+		} {
+			if _, err := Product(client, other); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+}
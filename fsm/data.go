@@ -0,0 +1,94 @@
+package fsm
+
+import (
+	"context"
+)
+
+// Data is the extended state of a machine: values beyond the current state
+// that guards can read and actions can change, such as counters or totals.
+type Data map[string]interface{}
+
+// Clone returns a copy of d.  Values are copied as is, so values that are
+// changed by actions should be replaced with SetData rather than modified in
+// place.
+func (d Data) Clone() Data {
+	if d == nil {
+		return nil
+	}
+	out := make(Data, len(d))
+	for k, v := range d {
+		out[k] = v
+	}
+	return out
+}
+
+// extended is the extended state seen by a single step.  Writes go to a copy
+// of the machine's data, made on the first write, which the machine keeps if
+// the step succeeds.
+type extended struct {
+	base Data
+	work Data
+}
+
+type dataKey struct{}
+
+// WithExtendedState sets the initial extended state of the machine.  Reset
+// returns the machine to it.
+func WithExtendedState(d Data) Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.initial = d.Clone()
+	}
+}
+
+// ExtendedState returns a copy of the machine's extended state
+func (m *machine) ExtendedState() Data {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.extendedState().Clone()
+}
+
+// GetData returns the value stored under key in the extended state of the
+// machine evaluating ctx.  It is meant to be called from guards and actions,
+// and returns nil when called with any other context.
+func GetData(ctx context.Context, key string) interface{} {
+	ext, ok := ctx.Value(dataKey{}).(*extended)
+	if !ok {
+		return nil
+	}
+	if ext.work != nil {
+		return ext.work[key]
+	}
+	return ext.base[key]
+}
+
+// SetData stores value under key in the extended state of the machine
+// evaluating ctx.  It is meant to be called from actions: the change is only
+// kept if the whole transition succeeds, and is discarded along with any
+// other change if an action returns an error.  It does nothing when called
+// with any other context.
+func SetData(ctx context.Context, key string, value interface{}) {
+	ext, ok := ctx.Value(dataKey{}).(*extended)
+	if !ok {
+		return
+	}
+	if ext.work == nil {
+		ext.work = make(Data, len(ext.base)+1)
+		for k, v := range ext.base {
+			ext.work[k] = v
+		}
+	}
+	ext.work[key] = value
+}
+
+// extendedState returns the machine's current extended state, falling back
+// to its initial extended state.  The caller must hold m.mu.
+func (m *machine) extendedState() Data {
+	if m.data == nil {
+		return m.initial
+	}
+	return m.data
+}
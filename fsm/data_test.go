@@ -0,0 +1,145 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// attempts allows three failed logins before locking the account
+func attempts(t *testing.T) *machine {
+	t.Helper()
+
+	var (
+		loggedOut = NewState("loggedOut")
+		loggedIn  = NewState("loggedIn")
+		locked    = NewState("locked")
+	)
+	failures := func(ctx context.Context) int {
+		n, _ := GetData(ctx, "failures").(int)
+		return n
+	}
+	tooMany := func(ctx context.Context, v interface{}) (bool, error) {
+		return v == "fail" && failures(ctx) >= 2, nil
+	}
+	count := func(ctx context.Context, _ interface{}) error {
+		SetData(ctx, "failures", failures(ctx)+1)
+		return nil
+	}
+
+	return NewMachine(
+		WithExtendedState(Data{"failures": 0}),
+		WithTransitions(
+			Do(loggedOut.When("lock", tooMany), count).Then(locked),
+			Do(loggedOut.When("fail", strIs("fail")), count).Then(loggedOut),
+			loggedOut.When("login", strIs("login")).Then(loggedIn),
+		),
+	)
+}
+
+func TestExtendedState(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("guards read and actions write", func(t *testing.T) {
+		m := attempts(t)
+		for i, want := range []string{"loggedOut", "loggedOut", "locked"} {
+			if _, err := m.Update(ctx, "fail"); err != nil {
+				t.Fatal(err)
+			}
+			if m.Current().Name() != want {
+				t.Fatalf("attempt %d: expected %s, got %s", i+1, want, m.Current().Name())
+			}
+		}
+		if got := m.ExtendedState(); !reflect.DeepEqual(got, Data{"failures": 3}) {
+			t.Fatalf("unexpected extended state: %v", got)
+		}
+
+		if err := m.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.ExtendedState(); !reflect.DeepEqual(got, Data{"failures": 0}) {
+			t.Fatalf("unexpected extended state after reset: %v", got)
+		}
+	})
+
+	t.Run("copies are independent", func(t *testing.T) {
+		m := attempts(t)
+		m.ExtendedState()["failures"] = 10
+		if got := m.ExtendedState(); !reflect.DeepEqual(got, Data{"failures": 0}) {
+			t.Fatalf("unexpected extended state: %v", got)
+		}
+	})
+
+	t.Run("failed actions roll back", func(t *testing.T) {
+		errAction := errors.New("action failed")
+		m := attempts(t)
+		m.AddTransition(Do(m.lookup("loggedIn").When("logout", strIs("logout")), func(ctx context.Context, _ interface{}) error {
+			SetData(ctx, "failures", 100)
+			return errAction
+		}).Then(m.lookup("loggedOut")))
+
+		if _, err := m.Update(ctx, "login"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Update(ctx, "logout"); !errors.Is(err, errAction) {
+			t.Fatalf("expected action error, got %v", err)
+		}
+		if got := m.ExtendedState(); !reflect.DeepEqual(got, Data{"failures": 0}) {
+			t.Fatalf("unexpected extended state: %v", got)
+		}
+	})
+
+	t.Run("snapshots", func(t *testing.T) {
+		m := attempts(t)
+		if _, err := m.Update(ctx, "fail"); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := json.Marshal(m.Snapshot())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			t.Fatal(err)
+		}
+		restored := attempts(t)
+		if err := restored.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+		if got := restored.ExtendedState(); !reflect.DeepEqual(got, Data{"failures": float64(1)}) {
+			t.Fatalf("unexpected extended state: %v", got)
+		}
+
+		snap.Data = nil
+		if err := restored.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+		if got := restored.ExtendedState(); len(got) != 0 {
+			t.Fatalf("unexpected extended state: %v", got)
+		}
+	})
+
+	t.Run("instances", func(t *testing.T) {
+		def, err := attempts(t).Define()
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, b := def.NewInstance(), def.NewInstance()
+		if _, err := a.Update(ctx, "fail"); err != nil {
+			t.Fatal(err)
+		}
+		if a.ExtendedState()["failures"] != 1 || b.ExtendedState()["failures"] != 0 {
+			t.Fatalf("unexpected extended state: a=%v b=%v", a.ExtendedState(), b.ExtendedState())
+		}
+	})
+
+	t.Run("outside a machine", func(t *testing.T) {
+		SetData(ctx, "x", 1)
+		if GetData(ctx, "x") != nil {
+			t.Fatal("expected no data")
+		}
+	})
+}
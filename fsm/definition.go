@@ -0,0 +1,230 @@
+package fsm
+
+import (
+	"context"
+	"io"
+)
+
+// graph is the definition of a machine: its states, the transitions between
+// them and the settings shared by every instance of it
+type graph struct {
+	start             State
+	endStates         map[uint64]State
+	transitions       map[uint64][]Transition
+	tables            map[uint64]*table
+	declared          map[uint64]State
+	parents           map[uint64]State
+	children          map[uint64][]State
+	regions           []region
+	initial           Data
+	clock             Clock
+	onError           func(error)
+	observers         []Observer
+	interceptors      []Interceptor
+	typed             func(interface{}) (interface{}, error)
+	noTransitionError bool
+	strict            bool
+	nondeterministic  bool
+}
+
+// clone returns a copy of g that shares no maps or slices with it.  The
+// transitions created by this package are copied too, so that calling Do or
+// Priority on them later does not change the copy; transitions of other
+// types are shared.
+func (g graph) clone() graph {
+	c := g
+	c.endStates = cloneStates(g.endStates)
+	c.declared = cloneStates(g.declared)
+	c.parents = cloneStates(g.parents)
+	c.tables = nil
+	if g.transitions != nil {
+		c.transitions = make(map[uint64][]Transition, len(g.transitions))
+		c.tables = make(map[uint64]*table, len(g.transitions))
+		for id, tt := range g.transitions {
+			copied := make([]Transition, len(tt))
+			for i, t := range tt {
+				copied[i] = cloneTransition(t)
+			}
+			c.transitions[id] = copied
+			c.tables[id] = newTable(copied)
+		}
+	}
+	if g.children != nil {
+		c.children = make(map[uint64][]State, len(g.children))
+		for id, ss := range g.children {
+			c.children[id] = append([]State(nil), ss...)
+		}
+	}
+	c.regions = append([]region(nil), g.regions...)
+	c.initial = g.initial.Clone()
+	c.observers = append([]Observer(nil), g.observers...)
+	c.interceptors = append([]Interceptor(nil), g.interceptors...)
+	return c
+}
+
+func cloneTransition(t Transition) Transition {
+	e, ok := t.(*edge)
+	if !ok {
+		return t
+	}
+	c := *e
+	c.do = append([]ActionFunc(nil), e.do...)
+	return &c
+}
+
+func cloneStates(in map[uint64]State) map[uint64]State {
+	if in == nil {
+		return nil
+	}
+	out := make(map[uint64]State, len(in))
+	for id, s := range in {
+		out[id] = s
+	}
+	return out
+}
+
+// Definition is an immutable, validated machine.  It holds the states and
+// transitions once; any number of instances created from it share them and
+// only hold where they are and their extended state.
+type Definition struct {
+	m *machine
+}
+
+// NewDefinition builds a machine from opts, validates it and returns its
+// definition
+func NewDefinition(opts ...Option) (*Definition, error) {
+	return NewMachine(opts...).Define()
+}
+
+// Define validates the machine and returns a definition built from a copy of
+// it.  Later changes to the machine do not affect the definition.
+func (m *machine) Define() (*Definition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return &Definition{m: &machine{graph: m.graph.clone()}}, nil
+}
+
+// NewInstance returns a new instance of the definition in its start state
+func (d *Definition) NewInstance() *Instance {
+	m := &machine{graph: d.m.graph}
+	m.enterStart()
+	return &Instance{def: d, m: m}
+}
+
+// Analyze reports on the structure of the definition.  See Report.
+func (d *Definition) Analyze() Report {
+	return d.m.Analyze()
+}
+
+// Graph writes the definition in Graphviz DOT format
+func (d *Definition) Graph(w io.Writer) error {
+	return d.m.Graph(w)
+}
+
+// Mermaid writes the definition as a Mermaid state diagram
+func (d *Definition) Mermaid(w io.Writer) error {
+	return d.m.Mermaid(w)
+}
+
+// PlantUML writes the definition as a PlantUML state diagram
+func (d *Definition) PlantUML(w io.Writer) error {
+	return d.m.PlantUML(w)
+}
+
+// Instance is a running copy of a Definition.  It holds only its current
+// states, extended state, history and timers; the methods behave as the
+// machine methods of the same name.
+type Instance struct {
+	def *Definition
+	m   *machine
+}
+
+// Definition returns the definition the instance was created from
+func (i *Instance) Definition() *Definition {
+	return i.def
+}
+
+func (i *Instance) Update(ctx context.Context, value interface{}) (bool, error) {
+	return i.m.Update(ctx, value)
+}
+
+func (i *Instance) Step(ctx context.Context, value interface{}) (Result, error) {
+	return i.m.Step(ctx, value)
+}
+
+func (i *Instance) Run(ctx context.Context, inputs Seq) (int, error) {
+	return i.m.Run(ctx, inputs)
+}
+
+func (i *Instance) Accepts(ctx context.Context, inputs Seq) (bool, error) {
+	return i.m.Accepts(ctx, inputs)
+}
+
+func (i *Instance) Tick(ctx context.Context) (bool, error) {
+	return i.m.Tick(ctx)
+}
+
+func (i *Instance) Start(ctx context.Context) error {
+	return i.m.Start(ctx)
+}
+
+func (i *Instance) Stop() {
+	i.m.Stop()
+}
+
+func (i *Instance) Reset() error {
+	return i.m.Reset()
+}
+
+func (i *Instance) Current() State {
+	return i.m.Current()
+}
+
+func (i *Instance) CurrentPath() []State {
+	return i.m.CurrentPath()
+}
+
+func (i *Instance) CurrentStates() []State {
+	return i.m.CurrentStates()
+}
+
+func (i *Instance) ExtendedState() Data {
+	return i.m.ExtendedState()
+}
+
+func (i *Instance) IsEndState() bool {
+	return i.m.IsEndState()
+}
+
+func (i *Instance) Snapshot() Snapshot {
+	return i.m.Snapshot()
+}
+
+func (i *Instance) Restore(snap Snapshot) error {
+	return i.m.Restore(snap)
+}
+
+func (i *Instance) Subscribe(f Observer) func() {
+	return i.m.Subscribe(f)
+}
+
+func (i *Instance) Events(size int) (<-chan TransitionEvent, func()) {
+	return i.m.Events(size)
+}
+
+func (i *Instance) Graph(w io.Writer) error {
+	return i.m.Graph(w)
+}
+
+func (i *Instance) Mermaid(w io.Writer) error {
+	return i.m.Mermaid(w)
+}
+
+func (i *Instance) PlantUML(w io.Writer) error {
+	return i.m.PlantUML(w)
+}
@@ -0,0 +1,131 @@
+package fsm
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDefinition(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("instances are independent", func(t *testing.T) {
+		def, err := orderStatus(t).Define()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, b := def.NewInstance(), def.NewInstance()
+		if a.Definition() != def {
+			t.Fatal("unexpected definition")
+		}
+		if _, err := a.Update(ctx, "ship"); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(a.CurrentStates()); !reflect.DeepEqual(got, []string{"unpaid", "shipped"}) {
+			t.Fatalf("unexpected states: %v", got)
+		}
+		if got := names(b.CurrentStates()); !reflect.DeepEqual(got, []string{"unpaid", "pending"}) {
+			t.Fatalf("unexpected states: %v", got)
+		}
+
+		if err := b.Restore(a.Snapshot()); err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range []string{"pay", "deliver"} {
+			if _, err := b.Update(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !b.IsEndState() || a.IsEndState() {
+			t.Fatalf("unexpected end states: a=%v b=%v", a.IsEndState(), b.IsEndState())
+		}
+	})
+
+	t.Run("instances share the graph", func(t *testing.T) {
+		def, err := orderStatus(t).Define()
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := def.NewInstance()
+		if reflect.ValueOf(i.m.transitions).Pointer() != reflect.ValueOf(def.m.transitions).Pointer() {
+			t.Fatal("instance copied the transitions")
+		}
+	})
+
+	t.Run("definitions are immutable", func(t *testing.T) {
+		m := orderStatus(t)
+		def, err := m.Define()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m.AddTransition(m.lookup("paid").When("refund", strIs("refund")).Then(m.lookup("unpaid")))
+		i := def.NewInstance()
+		for _, input := range []string{"pay", "refund"} {
+			if _, err := i.Update(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i.Current().Name() != "paid" {
+			t.Fatalf("expected paid, got %s", i.Current().Name())
+		}
+	})
+
+	t.Run("transitions are copied", func(t *testing.T) {
+		a, b, c := NewState("a"), NewState("b"), NewState("c")
+		toB := On(a, "x").Then(b)
+		toC := a.When("x", strIs("x")).Then(c)
+		def, err := NewDefinition(WithTransitions(toB, toC))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ran := false
+		Do(toB, func(context.Context, interface{}) error {
+			ran = true
+			return nil
+		})
+		Priority(toC, 1)
+		i := def.NewInstance()
+		if _, err := i.Update(ctx, "x"); err != nil {
+			t.Fatal(err)
+		}
+		if i.Current().Name() != "b" || ran {
+			t.Fatalf("definition changed: in %s, action ran: %v", i.Current().Name(), ran)
+		}
+	})
+
+	t.Run("invalid machines", func(t *testing.T) {
+		if _, err := NewDefinition(); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("concurrent instances", func(t *testing.T) {
+		def, err := orderStatus(t).Define()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for n := 0; n < 50; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				i := def.NewInstance()
+				for _, input := range []string{"pay", "ship", "deliver"} {
+					if _, err := i.Update(ctx, input); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				if !i.IsEndState() {
+					t.Error("expected end state")
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
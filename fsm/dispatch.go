@@ -0,0 +1,84 @@
+package fsm
+
+// table indexes the transitions out of a state, so that the transitions
+// keyed on a value are found without evaluating any trigger functions.  For
+// every symbol, it holds the transitions keyed on it already merged with the
+// guarded transitions, in the order they are evaluated, so that looking up
+// the candidates for a value allocates nothing.
+type table struct {
+	symbols map[interface{}][]Transition
+	guarded []Transition
+}
+
+func newTable(tt []Transition) *table {
+	tb := &table{}
+	var keyed map[interface{}][]Transition
+	for _, t := range tt {
+		sym, ok := symbolOf(t)
+		if !ok {
+			tb.guarded = append(tb.guarded, t)
+			continue
+		}
+		if keyed == nil {
+			keyed = make(map[interface{}][]Transition)
+		}
+		keyed[sym] = append(keyed[sym], t)
+	}
+	if keyed == nil {
+		return tb
+	}
+
+	tb.symbols = make(map[interface{}][]Transition, len(keyed))
+	for sym, ks := range keyed {
+		tb.symbols[sym] = merge(ks, tb.guarded)
+	}
+	return tb
+}
+
+// merge returns the transitions of keyed and guarded in the order they are
+// evaluated: from the highest priority to the lowest, with the keyed
+// transitions before the guarded transitions of the same priority.  Both
+// are sorted by priority already.
+func merge(keyed, guarded []Transition) []Transition {
+	out := make([]Transition, 0, len(keyed)+len(guarded))
+	i, j := 0, 0
+	for i < len(keyed) && j < len(guarded) {
+		if priorityOf(keyed[i]) >= priorityOf(guarded[j]) {
+			out = append(out, keyed[i])
+			i++
+		} else {
+			out = append(out, guarded[j])
+			j++
+		}
+	}
+	out = append(out, keyed[i:]...)
+	return append(out, guarded[j:]...)
+}
+
+// candidates returns the transitions that may accept value, in the order
+// they are evaluated
+func (tb *table) candidates(value interface{}) []Transition {
+	if tb == nil {
+		return nil
+	}
+	if tt, ok := tb.on(value); ok {
+		return tt
+	}
+	return tb.guarded
+}
+
+// on returns the transitions keyed on value, merged with the guarded
+// transitions, and whether there are any
+func (tb *table) on(value interface{}) (tt []Transition, ok bool) {
+	if len(tb.symbols) == 0 {
+		return nil, false
+	}
+	defer func() {
+		// values that cannot be map keys match no symbol
+		if recover() != nil {
+			tt, ok = nil, false
+		}
+	}()
+	tt, ok = tb.symbols[value]
+	return tt, ok
+}
@@ -0,0 +1,263 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDispatch(t *testing.T) {
+	ctx := context.Background()
+	var calls int
+	counted := func(f TriggerFunc) TriggerFunc {
+		return func(ctx context.Context, v interface{}) (bool, error) {
+			calls++
+			return f(ctx, v)
+		}
+	}
+	always := func(context.Context, interface{}) (bool, error) {
+		return true, nil
+	}
+
+	t.Run("symbols before guards", func(t *testing.T) {
+		a, b, c, d := NewState("a"), NewState("b"), NewState("c"), NewState("d")
+		m := NewMachine(WithTransitions(
+			a.When("anything", counted(always)).Then(d),
+			On(a, 'x').Then(b),
+			On(a, 'x').Then(c),
+		))
+
+		calls = 0
+		if ok, err := m.Update(ctx, 'x'); !ok || err != nil {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+		if m.Current().Name() != "b" || calls != 0 {
+			t.Fatalf("expected the first symbol transition without guards, got %s after %d calls", m.Current().Name(), calls)
+		}
+
+		// other values fall back to the guards
+		_ = m.Reset()
+		if ok, err := m.Update(ctx, 'y'); !ok || err != nil {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+		if m.Current().Name() != "d" || calls != 1 {
+			t.Fatalf("expected the guard, got %s after %d calls", m.Current().Name(), calls)
+		}
+	})
+
+	t.Run("priorities", func(t *testing.T) {
+		a, b, c := NewState("a"), NewState("b"), NewState("c")
+		m := NewMachine(WithTransitions(
+			On(a, 1).Then(b),
+			Priority(On(a, 1), 1).Then(c),
+		))
+		if ok, err := m.Update(ctx, 1); !ok || err != nil || m.Current().Name() != "c" {
+			t.Fatalf("ok=%v err=%v state=%s", ok, err, m.Current().Name())
+		}
+
+		// guards with a higher priority come before symbols
+		m = NewMachine(WithTransitions(
+			On(a, 'x').Then(b),
+			On(a, 'y').Then(b),
+			Priority(a.When("not y", func(_ context.Context, v interface{}) (bool, error) {
+				return v != 'y', nil
+			}), 5).Then(c),
+		))
+		for _, tc := range []struct {
+			in   rune
+			want string
+		}{
+			{'x', "c"},
+			{'y', "b"},
+		} {
+			_ = m.Reset()
+			if _, err := m.Update(ctx, tc.in); err != nil || m.Current().Name() != tc.want {
+				t.Fatalf("%c: expected %s, got %s (err=%v)", tc.in, tc.want, m.Current().Name(), err)
+			}
+		}
+	})
+
+	t.Run("enclosing states", func(t *testing.T) {
+		parent, child, other, out := NewState("parent"), NewState("child"), NewState("other"), NewState("out")
+		m := NewMachine(
+			WithTransitions(
+				On(parent, "quit").Then(out),
+				child.When("anything", always).Then(other),
+			),
+			WithSubstates(parent, child, other),
+		)
+		// the child's guard takes precedence over its parent's symbol
+		if ok, err := m.Update(ctx, "quit"); !ok || err != nil || m.Current().Name() != "other" {
+			t.Fatalf("ok=%v err=%v state=%s", ok, err, m.Current().Name())
+		}
+		if ok, err := m.Update(ctx, "quit"); !ok || err != nil || m.Current().Name() != "out" {
+			t.Fatalf("ok=%v err=%v state=%s", ok, err, m.Current().Name())
+		}
+	})
+
+	t.Run("uncomparable values", func(t *testing.T) {
+		a, b := NewState("a"), NewState("b")
+		m := NewMachine(WithTransitions(On(a, 1).Then(b), a.When("slice", func(_ context.Context, v interface{}) (bool, error) {
+			_, ok := v.([]int)
+			return ok, nil
+		}).Then(a)))
+		if ok, err := m.Update(ctx, []int{1}); !ok || err != nil || m.Current().Name() != "a" {
+			t.Fatalf("ok=%v err=%v state=%s", ok, err, m.Current().Name())
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		a, b, c := NewState("a"), NewState("b"), NewState("c")
+		m := NewMachine(WithStrictTransitions(), WithTransitions(
+			On(a, 'x').Then(b),
+			On(a, 'y').Then(b),
+			Priority(a.When("anything", always), -1).Then(c),
+		))
+		if ok, err := m.Update(ctx, 'x'); !ok || err != nil {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+
+		// symbols and guards of the same priority are checked against each
+		// other
+		_ = m.Reset()
+		m.AddTransition(a.When("x or z", func(_ context.Context, v interface{}) (bool, error) {
+			return v == 'x' || v == 'z', nil
+		}).Then(c))
+		if _, err := m.Update(ctx, 'x'); !errors.Is(err, ErrAmbiguousTransition) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok, err := m.Update(ctx, 'y'); !ok || err != nil {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+
+		m.AddTransition(On(b, 'x').Then(c))
+		m.AddTransition(On(b, 'x').Then(a))
+		if _, err := m.Update(ctx, 'x'); !errors.Is(err, ErrAmbiguousTransition) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("interceptors", func(t *testing.T) {
+		a, b, c := NewState("a"), NewState("b"), NewState("c")
+		m := NewMachine(
+			WithTransitions(On(a, 'x').Then(b), On(a, 'x').Then(c)),
+			WithInterceptors(Interceptor{
+				Guard: func(ctx context.Context, tr Transition, v interface{}, next TriggerFunc) (bool, error) {
+					if tr.To().Name() == "b" {
+						return false, nil
+					}
+					return next(ctx, v)
+				},
+			}),
+		)
+		if ok, err := m.Update(ctx, 'x'); !ok || err != nil || m.Current().Name() != "c" {
+			t.Fatalf("ok=%v err=%v state=%s", ok, err, m.Current().Name())
+		}
+	})
+
+	t.Run("instances", func(t *testing.T) {
+		a, b, c := NewState("a"), NewState("b"), NewState("c")
+		base := NewMachine(WithTransitions(On(a, 1).Then(b)))
+		clone := &machine{graph: base.graph.clone()}
+		clone.AddTransition(Priority(On(a, 1), 1).Then(c))
+
+		if ok, _ := base.Update(ctx, 1); !ok || base.Current().Name() != "b" {
+			t.Fatalf("unexpected state: %s", base.Current().Name())
+		}
+		if ok, _ := clone.Update(ctx, 1); !ok || clone.Current().Name() != "c" {
+			t.Fatalf("unexpected state: %s", clone.Current().Name())
+		}
+	})
+}
+
+// lexer builds a machine with one transition per symbol out of its start
+// state, keyed on symbols or guarded by trigger functions
+func lexer(tb testing.TB, keyed bool) (*machine, []rune) {
+	tb.Helper()
+
+	start := NewState("start")
+	var (
+		transitions []Transition
+		symbols     []rune
+	)
+	for r := 'A'; r < 'A'+64; r++ {
+		to := NewState(fmt.Sprintf("token %c", r))
+		symbols = append(symbols, r)
+		if keyed {
+			transitions = append(transitions, On(start, r).Then(to))
+			continue
+		}
+		want := r
+		transitions = append(transitions, start.When(string(r), func(_ context.Context, v interface{}) (bool, error) {
+			return v == want, nil
+		}).Then(to))
+	}
+	m := NewMachine(WithTransitions(transitions...))
+	return m, symbols
+}
+
+func TestUpdateAllocs(t *testing.T) {
+	ctx := context.Background()
+	for _, keyed := range []bool{false, true} {
+		m, symbols := lexer(t, keyed)
+		var i int
+		allocs := testing.AllocsPerRun(100, func() {
+			if ok, err := m.Update(ctx, symbols[i%len(symbols)]); !ok || err != nil {
+				t.Fatalf("ok=%v err=%v", ok, err)
+			}
+			m.setCurrent(m.start)
+			i++
+		})
+		if allocs != 0 {
+			t.Fatalf("keyed=%v: expected no allocations, got %v", keyed, allocs)
+		}
+	}
+}
+
+// scan takes the first transition out of the current state whose trigger
+// function accepts value, as Update did before it supported nesting,
+// regions, extended state and symbol lookups.  It is the baseline for
+// BenchmarkUpdate.
+func scan(m *machine, ctx context.Context, value interface{}) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.transitions[m.current().Id()] {
+		ok, err := t.Go(ctx, value)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			m.setCurrent(t.To())
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	ctx := context.Background()
+	for _, bench := range []struct {
+		name   string
+		keyed  bool
+		update func(*machine, context.Context, interface{}) (bool, error)
+	}{
+		{"baseline", false, scan},
+		{"guards", false, (*machine).Update},
+		{"symbols", true, (*machine).Update},
+	} {
+		bench := bench
+		b.Run(bench.name, func(b *testing.B) {
+			m, symbols := lexer(b, bench.keyed)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := bench.update(m, ctx, symbols[i%len(symbols)]); err != nil {
+					b.Fatal(err)
+				}
+				m.setCurrent(m.start)
+			}
+		})
+	}
+}
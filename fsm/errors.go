@@ -0,0 +1,85 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoStartState is returned when a machine is used before it has a
+	// start state
+	ErrNoStartState = errors.New("machine has no start state")
+	// ErrNoTransition is returned by Update when no transition accepts the
+	// value, if the machine was built with WithNoTransitionError
+	ErrNoTransition = errors.New("no transition")
+	// ErrGuardFailed is matched by every GuardError
+	ErrGuardFailed = errors.New("guard failed")
+	// ErrAmbiguousTransition is returned by Update when more than one
+	// transition of the same priority accepts a value, if the machine was
+	// built with WithStrictTransitions
+	ErrAmbiguousTransition = errors.New("ambiguous transition")
+	// ErrUnknownState is returned when a state is referred to by a name the
+	// machine does not know
+	ErrUnknownState = errors.New("unknown state")
+)
+
+// GuardError is returned when the trigger function of a transition returns an
+// error or panics.  It matches ErrGuardFailed and wraps the cause.
+type GuardError struct {
+	Transition Transition
+	Err        error
+}
+
+func (e *GuardError) Error() string {
+	return fmt.Sprintf("guard failed: transition '%s': %v", e.Transition.Description(), e.Err)
+}
+
+func (e *GuardError) Unwrap() error {
+	return e.Err
+}
+
+func (e *GuardError) Is(target error) bool {
+	return target == ErrGuardFailed
+}
+
+// PanicError is the cause of a GuardError for a trigger function that
+// panicked
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// WithStrictTransitions makes Update evaluate every transition out of a state
+// that has the same priority as the one accepting the value, and return
+// ErrAmbiguousTransition if more than one accepts it.  Transitions of higher
+// priority, or out of states nested more deeply, still take precedence
+// without an error, as do transitions keyed on the value over the ones with
+// trigger functions.  It is meant for catching ambiguous machines in tests.
+func WithStrictTransitions() Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.strict = true
+	}
+}
+
+// WithNoTransitionError makes Update return ErrNoTransition instead of
+// (false, nil) when no transition accepts the value
+func WithNoTransitionError() Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.noTransitionError = true
+	}
+}
@@ -0,0 +1,101 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	errGuard := errors.New("guard error")
+
+	a := NewState("a")
+	b := NewState("b")
+	machine := func(f TriggerFunc, opts ...Option) *machine {
+		return NewMachine(append([]Option{WithTransitions(a.When("x", f).Then(b))}, opts...)...)
+	}
+
+	t.Run("guard errors", func(t *testing.T) {
+		m := machine(func(context.Context, interface{}) (bool, error) {
+			return false, errGuard
+		})
+		_, err := m.Update(ctx, "x")
+		if !errors.Is(err, ErrGuardFailed) || !errors.Is(err, errGuard) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var ge *GuardError
+		if !errors.As(err, &ge) || ge.Transition.Description() != "x" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("guard panics", func(t *testing.T) {
+		m := machine(func(context.Context, interface{}) (bool, error) {
+			panic("boom")
+		})
+		_, err := m.Update(ctx, "x")
+		var pe *PanicError
+		if !errors.Is(err, ErrGuardFailed) || !errors.As(err, &pe) || pe.Value != "boom" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// the machine is still usable
+		if m.Current().Name() != "a" {
+			t.Fatalf("expected a, got %s", m.Current().Name())
+		}
+
+		m = machine(func(context.Context, interface{}) (bool, error) {
+			panic(errGuard)
+		})
+		if _, err := m.Update(ctx, "x"); !errors.Is(err, errGuard) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("interceptor panics", func(t *testing.T) {
+		m := machine(strIs("x"), WithInterceptors(Interceptor{
+			Guard: func(context.Context, Transition, interface{}, TriggerFunc) (bool, error) {
+				panic("boom")
+			},
+		}))
+		if _, err := m.Update(ctx, "x"); !errors.Is(err, ErrGuardFailed) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("no transition", func(t *testing.T) {
+		if changed, err := machine(strIs("x")).Update(ctx, "y"); changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+
+		m := machine(strIs("x"), WithNoTransitionError())
+		if _, err := m.Update(ctx, "y"); !errors.Is(err, ErrNoTransition) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if changed, err := m.Update(ctx, "x"); !changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+	})
+
+	t.Run("no start state", func(t *testing.T) {
+		m := NewMachine()
+		if _, err := m.Update(ctx, "x"); !errors.Is(err, ErrNoStartState) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, err := range []error{m.Reset(), m.Validate()} {
+			if !errors.Is(err, ErrNoStartState) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+
+	t.Run("unknown states", func(t *testing.T) {
+		m := machine(strIs("x"))
+		for _, err := range []error{m.SetStart("c"), m.SetEndStates("c")} {
+			if !errors.Is(err, ErrUnknownState) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+}
@@ -5,19 +5,27 @@ import (
 	"errors"
 	"fmt"
 	"sync"
-	"sync/atomic"
+	"time"
 
 	"github.com/schigh/slice"
 )
 
 type machine struct {
 	mu sync.RWMutex
-	curr atomic.Value
-	start atomic.Value
-	endStates map[uint64]State
+	graph
+	curr State
 	idx uint32
-	transitions map[uint64][]Transition
+	parallel []State
+	set []State
+	data Data
+	history map[uint64]State
+	entered map[uint64]time.Time
+	wake chan struct{}
+	done chan struct{}
 	cancel func()
+	subscribers []*subscriber
+	queue []TransitionEvent
+	pub sync.Mutex
 }
 
 type Option func(*machine)
@@ -33,12 +41,24 @@ func WithTransitions(transitions ...Transition) Option {
 
 		// this sets the first state in the first transition as the root
 		if len(transitions) > 0 {
-			m.start.Store(transitions[0].From())
+			m.start = transitions[0].From()
 		}
 
 		for _, t := range transitions {
-			id := t.From().Id()
-			m.transitions[id] = append(m.transitions[id], t)
+			m.insert(t)
+		}
+	}
+}
+
+// WithStates declares the states that make up the machine.  When any states
+// are declared, Validate rejects transitions to or from undeclared states.
+func WithStates(states ...State) Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		for _, s := range states {
+			m.declare(s)
 		}
 	}
 }
@@ -48,39 +68,25 @@ func NewMachine(opts ...Option) *machine {
 	for _, f := range opts {
 		f(&m)
 	}
+	m.enterStart()
 
 	return &m
 }
 
-func (m *machine) Graph() {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	// TODO: traverse the fsm and generate graph
-}
-
 func (m *machine) SetStart(name string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	var start State
-	for _, t := range m.transitions {
-		tt := TransitionSlice(t)
-		tName := tt.Filter(func(tr Transition) bool {
-			return tr.From().Name() == name
-		})
-		if len(tName) > 0 {
-			start = tName[0].From()
-			break
-		}
-	}
-
+	start := m.lookup(name)
 	if start == nil {
-		return fmt.Errorf("no state found with name: %s", name)
+		return fmt.Errorf("%w: '%s'", ErrUnknownState, name)
 	}
 
-	m.start.Store(start)
-	m.curr.Store(start)
+	m.start = start
+	m.setCurrent(m.descend(start))
+	m.set = nil
+	m.enterStart()
+	m.notify()
 
 	return nil
 }
@@ -89,12 +95,16 @@ func (m *machine) Reset() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	starti := m.start.Load()
-	if starti == nil {
-		return errors.New("this machine has no start state")
+	if m.start == nil {
+		return ErrNoStartState
 	}
-	start, _ := starti.(State)
-	m.curr.Store(start)
+	m.setCurrent(m.descend(m.start))
+	m.parallel = nil
+	m.set = nil
+	m.data = nil
+	m.history = nil
+	m.enterStart()
+	m.notify()
 
 	return nil
 }
@@ -107,28 +117,14 @@ func (m *machine) SetEndStates(names  ...string) error {
 		m.endStates = make(map[uint64]State)
 	}
 
-	validNames := make(map[string]bool)
-
-	for _, t := range m.transitions {
-		tt := TransitionSlice(t)
-		tt.Filter(func(tr Transition) bool {
-			return slice.String(names).Contains(tr.To().Name())
-		}).Each(func(tr Transition) {
-			m.endStates[tr.To().Id()] = tr.To()
-			validNames[tr.To().Name()] = true
-		})
-	}
-
-	idx, ok := slice.String(names).IfEach(func(s string) bool {
-		_, ok := validNames[s]
-		return ok
-	})
-
-	if !ok {
-		return fmt.Errorf("invalid state: '%s'", names[idx])
+	for _, name := range names {
+		s := m.lookup(name)
+		if s == nil {
+			return fmt.Errorf("%w: '%s'", ErrUnknownState, name)
+		}
+		m.endStates[s.Id()] = s
 	}
 
-
 	return nil
 }
 
@@ -137,14 +133,29 @@ func (m *machine) IsEndState() bool {
 	defer m.mu.RUnlock()
 
 	if m.endStates == nil {
-		m.endStates = make(map[uint64]State)
 		return false
 	}
 
-	curr, _ := m.curr.Load().(State)
-	_, ok := m.endStates[curr.Id()]
+	if m.nondeterministic {
+		for _, s := range m.possible() {
+			if m.isFinal(s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	active := m.active()
+	if len(active) == 0 {
+		return false
+	}
+	for _, s := range active {
+		if !m.isFinal(s) {
+			return false
+		}
+	}
 
-	return ok
+	return true
 }
 
 func (m *machine) AddTransition(t Transition) {
@@ -158,22 +169,79 @@ func (m *machine) AddTransition(t Transition) {
 
 	if m.transitions == nil {
 		m.transitions = make(map[uint64][]Transition)
-		m.start.Store(from)
+		m.start = from
+		m.enterStart()
 	}
 
-	id := from.Id()
-	m.transitions[id] = append(m.transitions[id], t)
+	m.insert(t)
+}
+
+// insert adds t to the transitions out of its from state, after every
+// transition of the same or higher priority, and rebuilds the state's
+// lookup table.  The caller must hold m.mu.
+func (m *machine) insert(t Transition) {
+	id := t.From().Id()
+	tt := m.transitions[id]
+	i := len(tt)
+	for i > 0 && priorityOf(tt[i-1]) < priorityOf(t) {
+		i--
+	}
+	tt = append(tt, nil)
+	copy(tt[i+1:], tt[i:])
+	tt[i] = t
+	m.transitions[id] = tt
+
+	if m.tables == nil {
+		m.tables = make(map[uint64]*table)
+	}
+	m.tables[id] = newTable(tt)
+}
+
+func priorityOf(t Transition) int {
+	if p, ok := t.(interface{ Priority() int }); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+// AddState declares a state as part of the machine.  See WithStates.
+func (m *machine) AddState(s State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.declare(s)
+}
+
+// lookup finds a known state by name.  The caller must hold m.mu.
+func (m *machine) lookup(name string) State {
+	for _, s := range m.states() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (m *machine) declare(s State) {
+	if m.declared == nil {
+		m.declared = make(map[uint64]State)
+	}
+	m.declared[s.Id()] = s
 }
 
 func (m *machine) Validate() error {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	sm := make(map[uint64]State)
+	return m.validate()
+}
+
+// validate checks the machine's definition.  The caller must hold m.mu.
+func (m *machine) validate() error {
+	sm := make(map[interface{}]State)
 
-	start, _ := m.start.Load().(State)
-	if start == nil {
-		return errors.New("no start state set")
+	if m.start == nil {
+		return ErrNoStartState
 	}
 	var stateNames []string
 	for _, tt := range m.transitions {
@@ -184,69 +252,304 @@ func (m *machine) Validate() error {
 			if t.To() == nil {
 				return fmt.Errorf("transition '%s' has no to state", t.Description())
 			}
-			sm[t.From().Id()] = t.From()
-			sm[t.To().Id()] = t.To()
-			stateNames = append(stateNames, t.From().Name(), t.To().Name())
+			if isPseudo(t.From()) {
+				return fmt.Errorf("transition '%s' cannot start from a history state", t.Description())
+			}
+			to := anchor(t.To())
+			if to == nil || (isPseudo(t.To()) && len(m.children[to.Id()]) == 0) {
+				return fmt.Errorf("transition '%s' targets the history of a state with no substates", t.Description())
+			}
+			sm[instance(t.From())] = t.From()
+			sm[instance(to)] = to
+			stateNames = append(stateNames, t.From().Name(), to.Name())
 		}
 	}
+	for _, s := range m.declared {
+		sm[instance(s)] = s
+		stateNames = append(stateNames, s.Name())
+	}
 
 	stateNames = slice.String(stateNames).Unique()
 
 	if len(stateNames) != len(sm) {
 		return errors.New("invalid: all state names must be unique")
 	}
+	if err := m.validateKind(); err != nil {
+		return err
+	}
 
-	return nil
+	return m.analyze().Err()
 }
 
 func (m *machine) Current() State {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	curr, _ := m.curr.Load().(State)
-	if curr == nil {
-		curr, _ = m.start.Load().(State)
+	return m.current()
+}
+
+// current returns the current leaf state, falling back to the initial leaf
+// of the start state.  For an NFA machine, it returns the first of its
+// states.  The caller must hold m.mu.
+func (m *machine) current() State {
+	if m.nondeterministic {
+		if set := m.possible(); len(set) > 0 {
+			return set[0]
+		}
+		return nil
+	}
+
+	if m.curr == nil {
+		return m.descend(m.start)
 	}
-	return curr
+	return m.curr
 }
 
+// setCurrent stores the current leaf state.  The caller must hold m.mu.
+func (m *machine) setCurrent(s State) {
+	m.curr = s
+}
+
+// Update evaluates the transitions out of the current state against value,
+// taking the first one whose trigger returns true.  Transitions out of the
+// enclosing states of the current state are evaluated after its own, from
+// innermost to outermost.  The transitions of a state keyed on value with On
+// are found by looking value up, and come before its transitions with
+// trigger functions of the same priority.  Taking a transition runs the exit
+// actions of the states being left, the transition actions and the enter
+// actions of the states being entered, in that order.  If any action returns
+// an error, the error is returned and the current state is left unchanged.
+// When the machine has more than one region, value is dispatched to each of
+// them and Update reports whether any region changed state; an error in any
+// region leaves every region unchanged.  Trigger functions that return an
+// error or panic make Update return a GuardError.  If no transition accepts
+// value, Update returns (false, nil), or ErrNoTransition if the machine was
+// built with WithNoTransitionError.  Step does the same and reports what
+// happened in more detail.
 func (m *machine) Update(ctx context.Context, value interface{}) (bool, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	r, err := m.Step(ctx, value)
+	return r.Changed(), err
+}
 
-	select {
-	case <-ctx.Done():
-		return false, ctx.Err()
-	default:
+// step moves every region along the transition chosen for its current leaf
+// state by pick.  Transitions are picked for every region first, then their
+// actions run through the machine's commit interceptors, and the result is
+// committed only if all of them succeed.  Changes to the extended state are
+// committed with the new states, and an event is queued for every transition
+// taken.  The caller must hold m.mu.
+func (m *machine) step(ctx context.Context, value interface{}, pick func(context.Context, State) (Transition, error)) (Result, error) {
+	active := m.active()
+	if len(active) == 0 {
+		return Result{}, ErrNoStartState
 	}
 
-	curr, _ := m.curr.Load().(State)
-	if curr == nil {
-		curr, _ = m.start.Load().(State)
-		if curr == nil {
-			return false, errors.New("machine has no start state")
+	ext := &extended{base: m.extendedState()}
+	ctx = context.WithValue(ctx, dataKey{}, ext)
+
+	next := append([]State(nil), active...)
+	taken := make([]Transition, len(active))
+	exited := make([][]State, len(active))
+	entered := make([][]State, len(active))
+	var changed bool
+	for i, curr := range active {
+		t, err := pick(ctx, curr)
+		if err != nil {
+			return m.result(active, nil, active), err
 		}
+		if t == nil {
+			continue
+		}
+
+		changed = true
+		taken[i] = t
+		if t.To() == nil {
+			continue
+		}
+		exited[i], entered[i] = m.route(curr, t)
+		next[i] = entered[i][len(entered[i])-1]
 	}
-	transitions, ok := m.transitions[curr.Id()]
-	if !ok {
-		return false, nil
+	if !changed {
+		return m.result(active, nil, active), nil
 	}
 
-	for _, t := range transitions {
-		success, err := t.Go(ctx, value)
-		if err != nil {
-			return false, err
+	apply := func(ctx context.Context) error {
+		for i, t := range taken {
+			if t == nil {
+				continue
+			}
+			if err := take(ctx, value, exited[i], t, entered[i]); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+	c := Commit{Value: value, From: active, To: next, Transitions: taken}
+	if err := m.intercept(ctx, c, apply); err != nil {
+		return m.result(active, nil, active), err
+	}
 
-		if success {
-			to := t.To()
-			if to != nil {
-				m.curr.Store(to)
+	now := m.now()
+	for i := range active {
+		m.remember(active[i], exited[i])
+		m.track(now, exited[i], entered[i])
+	}
+	m.setActive(next)
+	if ext.work != nil {
+		m.data = ext.work
+	}
+	if m.observed() {
+		for i, t := range taken {
+			if t == nil {
+				continue
 			}
+			var region string
+			if i > 0 {
+				region = m.regions[i-1].name
+			}
+			m.queue = append(m.queue, TransitionEvent{
+				Region:     region,
+				From:       active[i],
+				To:         next[i],
+				Transition: t,
+				Value:      value,
+				Time:       now,
+			})
+		}
+	}
+	m.notify()
+
+	return m.result(active, taken, next), nil
+}
+
+// flat reports whether the machine is deterministic, with a single region
+// and no substates or interceptors, so that Step can use stepFlat.  The
+// caller must hold m.mu.
+func (m *machine) flat() bool {
+	return !m.nondeterministic && len(m.regions) == 0 && len(m.children) == 0 && len(m.interceptors) == 0
+}
+
+// stepFlat is step for a flat machine.  It makes the same moves without the
+// bookkeeping step needs for regions and nesting, and allocates nothing
+// unless the machine has extended state or the transition taken has actions
+// to run: only then do guards and actions get a context carrying the
+// extended state.  The caller must hold m.mu.
+func (m *machine) stepFlat(ctx context.Context, value interface{}) (Result, error) {
+	curr := m.current()
+	if curr == nil {
+		return Result{}, ErrNoStartState
+	}
+	r := Result{From: curr, To: curr, HasTransitions: m.hasTransitions(curr)}
 
-			return true, nil
+	var ext *extended
+	if base := m.extendedState(); len(base) > 0 {
+		ext = &extended{base: base}
+		ctx = context.WithValue(ctx, dataKey{}, ext)
+	}
+	t, err := m.first(ctx, curr, m.tables[curr.Id()].candidates(value), value)
+	if t == nil || err != nil {
+		return r, err
+	}
+
+	var exited, entered [1]State
+	exits, enters := exited[:0], entered[:0]
+	if t.To() != nil {
+		exits, enters = append(exits, curr), append(enters, m.resume(t.To()))
+	}
+	if !quiet(exits, t, enters) {
+		if ext == nil {
+			ext = &extended{base: m.extendedState()}
+			ctx = context.WithValue(ctx, dataKey{}, ext)
+		}
+		if err := take(ctx, value, exits, t, enters); err != nil {
+			return r, err
+		}
+	}
+
+	now := m.now()
+	m.track(now, exits, enters)
+	if len(enters) > 0 {
+		m.setCurrent(enters[0])
+		r.To = enters[0]
+	}
+	r.Transition = t
+	if ext != nil && ext.work != nil {
+		m.data = ext.work
+	}
+	if m.observed() {
+		m.queue = append(m.queue, TransitionEvent{From: curr, To: r.To, Transition: t, Value: value, Time: now})
+	}
+	m.notify()
+
+	return r, nil
+}
+
+// match returns the first transition out of curr whose trigger accepts
+// value, or nil if there is none.  Transitions on enclosing states apply to
+// curr, with the innermost state taking precedence.  Of the transitions of a
+// state keyed on a symbol, only those keyed on value are evaluated, before
+// the transitions with trigger functions of the same priority.  The caller
+// must hold m.mu.
+func (m *machine) match(ctx context.Context, curr State, value interface{}) (Transition, error) {
+	for s := curr; s != nil; s = m.parents[s.Id()] {
+		if t, err := m.first(ctx, s, m.tables[s.Id()].candidates(value), value); t != nil || err != nil {
+			return t, err
 		}
 	}
 
-	return false, nil
+	return nil, nil
+}
+
+// first returns the first of the transitions tt out of s whose trigger
+// accepts value.  In strict mode, the rest of tt with the same priority are
+// evaluated as well, and an error is returned if any of them also accepts
+// value.  The caller must hold m.mu.
+func (m *machine) first(ctx context.Context, s State, tt []Transition, value interface{}) (Transition, error) {
+	for i, t := range tt {
+		success, err := m.guard(ctx, t, value)
+		if err != nil {
+			return nil, err
+		}
+		if !success {
+			continue
+		}
+		if m.strict {
+			for _, other := range tt[i+1:] {
+				if priorityOf(other) != priorityOf(t) {
+					break
+				}
+				also, err := m.guard(ctx, other, value)
+				if err != nil {
+					return nil, err
+				}
+				if also {
+					return nil, fmt.Errorf("%w: '%s' and '%s' from '%s' both accept %v",
+						ErrAmbiguousTransition, t.Description(), other.Description(), s.Name(), value)
+				}
+			}
+		}
+		return t, nil
+	}
+
+	return nil, nil
+}
+
+// take runs the actions for a transition in order: the exit actions of the
+// exited states, innermost first, the transition actions, then the enter
+// actions of the entered states, outermost first.  The first error aborts the
+// sequence.
+func take(ctx context.Context, value interface{}, exits []State, t Transition, enters []State) error {
+	for _, s := range exits {
+		if err := exit(ctx, s, value); err != nil {
+			return err
+		}
+	}
+	if err := act(ctx, t, value); err != nil {
+		return err
+	}
+	for _, s := range enters {
+		if err := enter(ctx, s, value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
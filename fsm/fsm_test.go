@@ -2,6 +2,7 @@ package fsm
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -452,3 +453,85 @@ func TestMachine(t *testing.T) {
 		})
 	})
 }
+
+func TestPriority(t *testing.T) {
+	ctx := context.Background()
+	always := func(context.Context, interface{}) (bool, error) {
+		return true, nil
+	}
+
+	a := NewState("a")
+	low := NewState("low")
+	first := NewState("first")
+	second := NewState("second")
+	high := NewState("high")
+
+	t.Run("order", func(t *testing.T) {
+		m := NewMachine(WithTransitions(
+			Priority(a.When("low", always), -1).Then(low),
+			a.When("first", always).Then(first),
+			a.When("second", always).Then(second),
+		))
+		m.AddTransition(Priority(a.When("high", always), 1).Then(high))
+
+		var got []string
+		m.mu.RLock()
+		for _, tr := range m.transitions[a.Id()] {
+			got = append(got, tr.Description())
+		}
+		m.mu.RUnlock()
+		if want := []string{"high", "first", "second", "low"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+
+		if _, err := m.Update(ctx, "x"); err != nil {
+			t.Fatal(err)
+		}
+		if m.Current().Name() != "high" {
+			t.Fatalf("expected high, got %s", m.Current().Name())
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		m := NewMachine(
+			WithStrictTransitions(),
+			WithTransitions(
+				a.When("first", byteIs('x')).Then(first),
+				a.When("second", always).Then(second),
+				Priority(a.When("low", always), -1).Then(low),
+			),
+		)
+		if _, err := m.Update(ctx, byte('x')); !errors.Is(err, ErrAmbiguousTransition) {
+			t.Fatalf("expected ambiguous transition, got %v", err)
+		}
+		if m.Current().Name() != "a" {
+			t.Fatalf("expected a, got %s", m.Current().Name())
+		}
+
+		// only one transition of the highest priority accepts y
+		if _, err := m.Update(ctx, byte('y')); err != nil {
+			t.Fatal(err)
+		}
+		if m.Current().Name() != "second" {
+			t.Fatalf("expected second, got %s", m.Current().Name())
+		}
+	})
+
+	t.Run("strict nesting", func(t *testing.T) {
+		parent := NewState("parent")
+		m := NewMachine(
+			WithStrictTransitions(),
+			WithTransitions(
+				parent.When("outer", always).Then(low),
+				a.When("inner", always).Then(high),
+			),
+			WithSubstates(parent, a),
+		)
+		if _, err := m.Update(ctx, "x"); err != nil {
+			t.Fatal(err)
+		}
+		if m.Current().Name() != "high" {
+			t.Fatalf("expected high, got %s", m.Current().Name())
+		}
+	})
+}
@@ -0,0 +1,421 @@
+package fsm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Graph writes the machine as a Graphviz DOT document to w.  States are
+// rendered as nodes and transitions as edges labelled with their
+// descriptions.  The start state of each region is marked with an incoming
+// arrow, end states are drawn with a double circle, and current states are
+// filled.  Composite states are rendered as clusters containing their
+// substates.
+func (m *machine) Graph(w io.Writer) error {
+	d := m.diagram("n", func(parent string, deep bool) string {
+		if deep {
+			return parent + "_hd"
+		}
+		return parent + "_h"
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph fsm {")
+	if len(d.children) > 0 {
+		fmt.Fprintln(bw, "\tcompound=true;")
+	}
+	fmt.Fprintln(bw, "\trankdir=LR;")
+	fmt.Fprintln(bw, "\tnode [shape=circle];")
+
+	for i := range d.starts {
+		fmt.Fprintf(bw, "\t%s [shape=point];\n", startID(i))
+	}
+
+	var node func(s State, indent string)
+	node = func(s State, indent string) {
+		if children := d.children[s.Id()]; len(children) > 0 {
+			fmt.Fprintf(bw, "%ssubgraph cluster_%s {\n", indent, d.id(s))
+			fmt.Fprintf(bw, "%s\tlabel=%s;\n", indent, dotQuote(s.Name()))
+			if d.isEnd(s) {
+				fmt.Fprintf(bw, "%s\tstyle=bold;\n", indent)
+			}
+			for _, c := range children {
+				node(c, indent+"\t")
+			}
+			for _, h := range d.histories[s.Id()] {
+				fmt.Fprintf(bw, "%s\t%s [label=%s];\n", indent, d.id(h), dotQuote(historyLabel(h)))
+			}
+			fmt.Fprintf(bw, "%s}\n", indent)
+			return
+		}
+
+		attrs := []string{fmt.Sprintf("label=%s", dotQuote(s.Name()))}
+		if d.isEnd(s) {
+			attrs = append(attrs, "shape=doublecircle")
+		}
+		if d.isCurrent(s) {
+			attrs = append(attrs, "style=filled", "fillcolor=lightgrey")
+		}
+		fmt.Fprintf(bw, "%s%s [%s];\n", indent, d.id(s), strings.Join(attrs, ", "))
+	}
+	for _, s := range d.top() {
+		node(s, "\t")
+	}
+
+	// edges to and from composite states are drawn to their initial leaf
+	// and clipped at the cluster boundary
+	for i, start := range d.starts {
+		fmt.Fprintf(bw, "\t%s -> %s", startID(i), d.id(d.leaf(start)))
+		if d.isComposite(start) {
+			fmt.Fprintf(bw, " [lhead=cluster_%s]", d.id(start))
+		}
+		fmt.Fprintln(bw, ";")
+	}
+
+	for _, t := range d.edges {
+		attrs := []string{fmt.Sprintf("label=%s", dotQuote(t.Description()))}
+		if d.isComposite(t.From()) {
+			attrs = append(attrs, "ltail=cluster_"+d.id(t.From()))
+		}
+		if d.isComposite(t.To()) {
+			attrs = append(attrs, "lhead=cluster_"+d.id(t.To()))
+		}
+		fmt.Fprintf(bw, "\t%s -> %s [%s];\n", d.id(d.leaf(t.From())), d.id(d.leaf(t.To())), strings.Join(attrs, ", "))
+	}
+
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// Mermaid writes the machine as a Mermaid stateDiagram-v2 document to w.
+// End states transition to the terminal pseudo-state and current states are
+// given the "current" class.  Composite states are rendered as nested
+// states with their own initial pseudo-state.
+func (m *machine) Mermaid(w io.Writer) error {
+	d := m.diagram("s", func(parent string, deep bool) string {
+		if deep {
+			return parent + "_hd"
+		}
+		return parent + "_h"
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "stateDiagram-v2")
+
+	var node func(s State, indent string)
+	node = func(s State, indent string) {
+		fmt.Fprintf(bw, "%sstate \"%s\" as %s\n", indent, mermaidEscape(s.Name()), d.id(s))
+		children := d.children[s.Id()]
+		if len(children) == 0 {
+			return
+		}
+		fmt.Fprintf(bw, "%sstate %s {\n", indent, d.id(s))
+		for _, c := range children {
+			node(c, indent+"    ")
+		}
+		for _, h := range d.histories[s.Id()] {
+			fmt.Fprintf(bw, "%s    state \"%s\" as %s\n", indent, historyLabel(h), d.id(h))
+		}
+		fmt.Fprintf(bw, "%s    [*] --> %s\n", indent, d.id(children[0]))
+		fmt.Fprintf(bw, "%s}\n", indent)
+	}
+	for _, s := range d.top() {
+		node(s, "    ")
+	}
+
+	for _, start := range d.starts {
+		fmt.Fprintf(bw, "    [*] --> %s\n", d.id(start))
+	}
+
+	for _, t := range d.edges {
+		fmt.Fprintf(bw, "    %s --> %s", d.id(t.From()), d.id(t.To()))
+		if desc := t.Description(); desc != "" {
+			fmt.Fprintf(bw, " : %s", mermaidEscape(desc))
+		}
+		fmt.Fprintln(bw)
+	}
+
+	for _, s := range d.states {
+		if d.isEnd(s) {
+			fmt.Fprintf(bw, "    %s --> [*]\n", d.id(s))
+		}
+	}
+
+	if len(d.active) > 0 {
+		ids := make([]string, 0, len(d.active))
+		for _, s := range d.active {
+			ids = append(ids, d.id(s))
+		}
+		fmt.Fprintln(bw, "    classDef current fill:#d3d3d3")
+		fmt.Fprintf(bw, "    class %s current\n", strings.Join(ids, ","))
+	}
+
+	return bw.Flush()
+}
+
+// PlantUML writes the machine as a PlantUML state diagram to w.  End states
+// transition to the terminal pseudo-state and current states are filled.
+// Composite states are rendered as nested states with their own initial
+// pseudo-state.
+func (m *machine) PlantUML(w io.Writer) error {
+	d := m.diagram("S", func(parent string, deep bool) string {
+		if deep {
+			return parent + "[H*]"
+		}
+		return parent + "[H]"
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "@startuml")
+
+	var node func(s State, indent string)
+	node = func(s State, indent string) {
+		fmt.Fprintf(bw, "%sstate \"%s\" as %s", indent, plantUMLEscape(s.Name()), d.id(s))
+		if d.isCurrent(s) {
+			fmt.Fprint(bw, " #lightgrey")
+		}
+		children := d.children[s.Id()]
+		if len(children) == 0 {
+			fmt.Fprintln(bw)
+			return
+		}
+		fmt.Fprintln(bw, " {")
+		for _, c := range children {
+			node(c, indent+"  ")
+		}
+		fmt.Fprintf(bw, "%s  [*] --> %s\n", indent, d.id(children[0]))
+		fmt.Fprintf(bw, "%s}\n", indent)
+	}
+	for _, s := range d.top() {
+		node(s, "")
+	}
+
+	for _, start := range d.starts {
+		fmt.Fprintf(bw, "[*] --> %s\n", d.id(start))
+	}
+
+	for _, t := range d.edges {
+		fmt.Fprintf(bw, "%s --> %s", d.id(t.From()), d.id(t.To()))
+		if desc := t.Description(); desc != "" {
+			fmt.Fprintf(bw, " : %s", plantUMLEscape(desc))
+		}
+		fmt.Fprintln(bw)
+	}
+
+	for _, s := range d.states {
+		if d.isEnd(s) {
+			fmt.Fprintf(bw, "%s --> [*]\n", d.id(s))
+		}
+	}
+
+	fmt.Fprintln(bw, "@enduml")
+
+	return bw.Flush()
+}
+
+// diagram is a point-in-time view of the machine shared by the exporters.
+// History pseudo-states are identified by applying pseudo to the id of
+// their parent.
+type diagram struct {
+	states    []State
+	edges     []Transition
+	ids       map[uint64]string
+	ends      map[uint64]State
+	parents   map[uint64]State
+	children  map[uint64][]State
+	starts    []State
+	active    []State
+	pseudo    func(parent string, deep bool) string
+	histories map[uint64][]State
+}
+
+func (m *machine) diagram(prefix string, pseudo func(parent string, deep bool) string) diagram {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d := diagram{
+		states:    m.states(),
+		ends:      make(map[uint64]State, len(m.endStates)),
+		parents:   make(map[uint64]State, len(m.parents)),
+		children:  make(map[uint64][]State, len(m.children)),
+		starts:    m.roots(),
+		active:    m.currentStates(),
+		pseudo:    pseudo,
+		histories: make(map[uint64][]State),
+	}
+	d.ids = make(map[uint64]string, len(d.states))
+	for i, s := range d.states {
+		d.ids[s.Id()] = fmt.Sprintf("%s%d", prefix, i)
+		d.edges = append(d.edges, m.transitions[s.Id()]...)
+	}
+	for id, s := range m.endStates {
+		d.ends[id] = s
+	}
+	seen := make(map[string]bool)
+	for _, t := range d.edges {
+		if h, ok := t.To().(*history); ok && !seen[h.Name()] {
+			seen[h.Name()] = true
+			d.histories[h.parent.Id()] = append(d.histories[h.parent.Id()], h)
+		}
+	}
+	for id, s := range m.parents {
+		d.parents[id] = s
+	}
+	for id, c := range m.children {
+		d.children[id] = append([]State(nil), c...)
+	}
+
+	return d
+}
+
+// top returns the states that are not nested in another state
+func (d diagram) top() []State {
+	var out []State
+	for _, s := range d.states {
+		if _, ok := d.parents[s.Id()]; !ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// leaf follows initial substates from s down to a leaf state
+func (d diagram) leaf(s State) State {
+	for children := d.children[s.Id()]; len(children) > 0; children = d.children[s.Id()] {
+		s = children[0]
+	}
+	return s
+}
+
+func (d diagram) id(s State) string {
+	if h, ok := s.(*history); ok {
+		return d.pseudo(d.ids[h.parent.Id()], h.deep)
+	}
+	return d.ids[s.Id()]
+}
+
+func (d diagram) isComposite(s State) bool {
+	return len(d.children[s.Id()]) > 0
+}
+
+func (d diagram) isEnd(s State) bool {
+	_, ok := d.ends[s.Id()]
+	return ok
+}
+
+func (d diagram) isCurrent(s State) bool {
+	for _, a := range d.active {
+		if a.Id() == s.Id() {
+			return true
+		}
+	}
+	return false
+}
+
+func historyLabel(s State) string {
+	if h, ok := s.(*history); ok && h.deep {
+		return "H*"
+	}
+	return "H"
+}
+
+func startID(i int) string {
+	if i == 0 {
+		return "__start"
+	}
+	return fmt.Sprintf("__start%d", i)
+}
+
+// states returns every state known to the machine in a stable order: first
+// the states reachable from the start state of each region in breadth-first
+// order, following transitions in the order they were added, then any
+// remaining states sorted by name.  The caller must hold m.mu.
+func (m *machine) states() []State {
+	seen := make(map[uint64]bool)
+	var out []State
+
+	visit := func(s State) {
+		if s == nil || seen[s.Id()] {
+			return
+		}
+		seen[s.Id()] = true
+		out = append(out, s)
+	}
+
+	var i int
+	for _, root := range m.roots() {
+		visit(root)
+		for ; i < len(out); i++ {
+			for _, c := range m.children[out[i].Id()] {
+				visit(c)
+			}
+			for _, t := range m.transitions[out[i].Id()] {
+				visit(anchor(t.To()))
+			}
+		}
+	}
+
+	var rest []State
+	for _, tt := range m.transitions {
+		for _, t := range tt {
+			for _, s := range []State{t.From(), anchor(t.To())} {
+				if s != nil && !seen[s.Id()] {
+					seen[s.Id()] = true
+					rest = append(rest, s)
+				}
+			}
+		}
+	}
+	for _, states := range []map[uint64]State{m.endStates, m.declared, m.parents} {
+		for _, s := range states {
+			if !seen[s.Id()] {
+				seen[s.Id()] = true
+				rest = append(rest, s)
+			}
+		}
+	}
+	for _, children := range m.children {
+		for _, s := range children {
+			if !seen[s.Id()] {
+				seen[s.Id()] = true
+				rest = append(rest, s)
+			}
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return rest[i].Name() < rest[j].Name()
+	})
+
+	return append(out, rest...)
+}
+
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "", "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// mermaidEscape replaces characters that would otherwise terminate or
+// confuse a Mermaid statement with entity codes
+func mermaidEscape(s string) string {
+	r := strings.NewReplacer(
+		"#", "#35;",
+		`"`, "#quot;",
+		";", "#59;",
+		"<", "#lt;",
+		">", "#gt;",
+		"{", "#123;",
+		"}", "#125;",
+		"\r", "",
+		"\n", "<br/>",
+	)
+	return r.Replace(s)
+}
+
+func plantUMLEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, "<U+0022>", "\r", "", "\n", `\n`)
+	return r.Replace(s)
+}
@@ -0,0 +1,190 @@
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func byteIs(b byte) TriggerFunc {
+	return func(_ context.Context, v interface{}) (bool, error) {
+		c, ok := v.(byte)
+		return ok && c == b, nil
+	}
+}
+
+// recognizer builds a machine matching a+bc
+func recognizer(t *testing.T) *machine {
+	t.Helper()
+
+	s1 := NewState("start")
+	s2 := NewState("a")
+	s3 := NewState("b")
+	s4 := NewState("c")
+
+	m := NewMachine(WithTransitions(
+		s1.When("a", byteIs('a')).Then(s2),
+		s2.When("a", byteIs('a')).Then(s2),
+		s2.When("b", byteIs('b')).Then(s3),
+		s3.When("c", byteIs('c')).Then(s4),
+	))
+	if err := m.SetEndStates("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestGraph(t *testing.T) {
+	m := recognizer(t)
+	if _, err := m.Update(context.Background(), byte('a')); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := m.Graph(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `digraph fsm {
+	rankdir=LR;
+	node [shape=circle];
+	__start [shape=point];
+	n0 [label="start"];
+	n1 [label="a", style=filled, fillcolor=lightgrey];
+	n2 [label="b"];
+	n3 [label="c", shape=doublecircle];
+	__start -> n0;
+	n0 -> n1 [label="a"];
+	n1 -> n1 [label="a"];
+	n1 -> n2 [label="b"];
+	n2 -> n3 [label="c"];
+}
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected graph:\n%s", got)
+	}
+}
+
+func TestGraphEscapesLabels(t *testing.T) {
+	s1 := NewState(`say "hi"`)
+	s2 := NewState("done")
+	m := NewMachine(WithTransitions(
+		s1.When("v == \"x\"\nor \\", byteIs('x')).Then(s2),
+	))
+
+	var buf bytes.Buffer
+	if err := m.Graph(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`n0 [label="say \"hi\"", style=filled, fillcolor=lightgrey];`,
+		`n0 -> n1 [label="v == \"x\"\nor \\"];`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in graph:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	m := recognizer(t)
+
+	var buf bytes.Buffer
+	if err := m.Mermaid(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `stateDiagram-v2
+    state "start" as s0
+    state "a" as s1
+    state "b" as s2
+    state "c" as s3
+    [*] --> s0
+    s0 --> s1 : a
+    s1 --> s1 : a
+    s1 --> s2 : b
+    s2 --> s3 : c
+    s3 --> [*]
+    classDef current fill:#d3d3d3
+    class s0 current
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected diagram:\n%s", got)
+	}
+}
+
+func TestMermaidEscapesLabels(t *testing.T) {
+	s1 := NewState(`say "hi"`)
+	s2 := NewState("done")
+	m := NewMachine(WithTransitions(
+		s1.When("v == #1; {x}\n<y>", byteIs('x')).Then(s2),
+	))
+
+	var buf bytes.Buffer
+	if err := m.Mermaid(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`state "say #quot;hi#quot;" as s0`,
+		`s0 --> s1 : v == #35;1#59; #123;x#125;<br/>#lt;y#gt;`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in diagram:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestPlantUML(t *testing.T) {
+	m := recognizer(t)
+	if _, err := m.Update(context.Background(), byte('a')); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := m.PlantUML(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `@startuml
+state "start" as S0
+state "a" as S1 #lightgrey
+state "b" as S2
+state "c" as S3
+[*] --> S0
+S0 --> S1 : a
+S1 --> S1 : a
+S1 --> S2 : b
+S2 --> S3 : c
+S3 --> [*]
+@enduml
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected diagram:\n%s", got)
+	}
+}
+
+func TestPlantUMLEscapesLabels(t *testing.T) {
+	s1 := NewState(`say "hi"`)
+	s2 := NewState("done")
+	m := NewMachine(WithTransitions(
+		s1.When("v == \"x\"\nor \\", byteIs('x')).Then(s2),
+	))
+
+	var buf bytes.Buffer
+	if err := m.PlantUML(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`state "say <U+0022>hi<U+0022>" as S0 #lightgrey`,
+		`S0 --> S1 : v == <U+0022>x<U+0022>\nor \\`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in diagram:\n%s", want, buf.String())
+		}
+	}
+}
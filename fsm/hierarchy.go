@@ -0,0 +1,143 @@
+package fsm
+
+import (
+	"fmt"
+)
+
+// WithSubstates makes children nested states of parent.  The first child is
+// the initial substate, entered whenever a transition targets parent.  The
+// machine is only ever in a leaf state; transitions defined on parent apply
+// to every descendant.
+func WithSubstates(parent State, children ...State) Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.nest(parent, children...)
+	}
+}
+
+// AddSubstates makes children nested states of parent.  See WithSubstates.
+func (m *machine) AddSubstates(parent State, children ...State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nest(parent, children...)
+}
+
+// CurrentPath returns the active states from the outermost composite state
+// down to the current leaf state
+func (m *machine) CurrentPath() []State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	curr := m.current()
+	if curr == nil {
+		return nil
+	}
+
+	return reverse(m.ancestors(curr))
+}
+
+func (m *machine) nest(parent State, children ...State) {
+	if parent == nil || len(children) == 0 {
+		panic("substates must have a parent and at least one child")
+	}
+	if m.parents == nil {
+		m.parents = make(map[uint64]State)
+		m.children = make(map[uint64][]State)
+	}
+
+	for _, c := range children {
+		if c == nil {
+			panic("substates must have a parent and at least one child")
+		}
+		if p, ok := m.parents[c.Id()]; ok && p.Id() != parent.Id() {
+			panic(fmt.Sprintf("state '%s' is already a substate of '%s'", c.Name(), p.Name()))
+		}
+		for _, a := range m.ancestors(parent) {
+			if a.Id() == c.Id() {
+				panic(fmt.Sprintf("state '%s' cannot be a substate of its own descendant '%s'", c.Name(), parent.Name()))
+			}
+		}
+		if _, ok := m.parents[c.Id()]; ok {
+			continue
+		}
+		m.parents[c.Id()] = parent
+		m.children[parent.Id()] = append(m.children[parent.Id()], c)
+	}
+}
+
+// ancestors returns s followed by each of its enclosing states, innermost
+// first.  The caller must hold m.mu.
+func (m *machine) ancestors(s State) []State {
+	var out []State
+	for ; s != nil; s = m.parents[s.Id()] {
+		out = append(out, s)
+	}
+	return out
+}
+
+// descend follows initial substates from s down to a leaf state.  The caller
+// must hold m.mu.
+func (m *machine) descend(s State) State {
+	if s == nil {
+		return nil
+	}
+	for children := m.children[s.Id()]; len(children) > 0; children = m.children[s.Id()] {
+		s = children[0]
+	}
+	return s
+}
+
+// domain returns the innermost state that is a proper ancestor of both from
+// and to, or nil if the transition crosses the top level of the machine.
+// The caller must hold m.mu.
+func (m *machine) domain(from, to State) State {
+	above := make(map[uint64]bool)
+	for _, a := range m.ancestors(to)[1:] {
+		above[a.Id()] = true
+	}
+	for _, a := range m.ancestors(from)[1:] {
+		if above[a.Id()] {
+			return a
+		}
+	}
+	return nil
+}
+
+// route returns the states exited and entered, in order, when the machine
+// takes transition t while in the leaf state curr.  Self transitions exit
+// and re-enter their state, and transitions to a history pseudo-state exit
+// and re-enter its parent.  The last entered state is the new leaf.  The
+// caller must hold m.mu.
+func (m *machine) route(curr State, t Transition) (exits, enters []State) {
+	d := m.domain(t.From(), anchor(t.To()))
+	within := func(s State) bool {
+		return s != nil && (d == nil || s.Id() != d.Id())
+	}
+
+	for s := curr; within(s); s = m.parents[s.Id()] {
+		exits = append(exits, s)
+	}
+	target := m.resume(t.To())
+	for s := target; within(s); s = m.parents[s.Id()] {
+		enters = append(enters, s)
+	}
+	enters = reverse(enters)
+
+	for s := target; len(m.children[s.Id()]) > 0; {
+		s = m.children[s.Id()][0]
+		enters = append(enters, s)
+	}
+
+	return exits, enters
+}
+
+func reverse(states []State) []State {
+	out := make([]State, len(states))
+	for i, s := range states {
+		out[len(states)-1-i] = s
+	}
+	return out
+}
@@ -0,0 +1,280 @@
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func strIs(want string) TriggerFunc {
+	return func(_ context.Context, v interface{}) (bool, error) {
+		s, ok := v.(string)
+		return ok && s == want, nil
+	}
+}
+
+// orderMachine builds an order lifecycle where Active contains Picking,
+// Packing and Shipping, and any active order can be cancelled
+func orderMachine(t *testing.T) (*machine, map[string]State) {
+	t.Helper()
+
+	states := make(map[string]State)
+	for _, name := range []string{"Idle", "Active", "Picking", "Packing", "Shipping", "Delivered", "Cancelled"} {
+		states[name] = NewState(name)
+	}
+
+	m := NewMachine(
+		WithTransitions(
+			states["Idle"].When("start", strIs("start")).Then(states["Active"]),
+			states["Picking"].When("picked", strIs("picked")).Then(states["Packing"]),
+			states["Packing"].When("packed", strIs("packed")).Then(states["Shipping"]),
+			states["Shipping"].When("delivered", strIs("delivered")).Then(states["Delivered"]),
+			states["Active"].When("cancel", strIs("cancel")).Then(states["Cancelled"]),
+			states["Active"].When("restart", strIs("restart")).Then(states["Active"]),
+		),
+		WithSubstates(states["Active"], states["Picking"], states["Packing"], states["Shipping"]),
+	)
+	if err := m.SetEndStates("Delivered", "Cancelled"); err != nil {
+		t.Fatal(err)
+	}
+
+	return m, states
+}
+
+func TestHierarchy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("enters initial substate", func(t *testing.T) {
+		m, _ := orderMachine(t)
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := m.Update(ctx, "start"); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(m.CurrentPath()); !reflect.DeepEqual(got, []string{"Active", "Picking"}) {
+			t.Fatalf("unexpected path: %v", got)
+		}
+		if m.Current().Name() != "Picking" {
+			t.Fatalf("expected Picking, got %s", m.Current().Name())
+		}
+	})
+
+	t.Run("parent transitions apply to every substate", func(t *testing.T) {
+		for _, steps := range [][]string{
+			{"start"},
+			{"start", "picked"},
+			{"start", "picked", "packed"},
+		} {
+			m, _ := orderMachine(t)
+			for _, step := range steps {
+				if changed, err := m.Update(ctx, step); err != nil || !changed {
+					t.Fatalf("%s: changed=%v err=%v", step, changed, err)
+				}
+			}
+			if changed, err := m.Update(ctx, "cancel"); err != nil || !changed {
+				t.Fatalf("cancel: changed=%v err=%v", changed, err)
+			}
+			if m.Current().Name() != "Cancelled" || !m.IsEndState() {
+				t.Fatalf("expected Cancelled, got %s", m.Current().Name())
+			}
+		}
+	})
+
+	t.Run("actions run outermost to innermost", func(t *testing.T) {
+		var calls []string
+		states := make(map[string]State)
+		for _, name := range []string{"Idle", "Active", "Picking", "Packing", "Cancelled"} {
+			name := name
+			states[name] = NewState(name,
+				OnEnter(func(context.Context, interface{}) error {
+					calls = append(calls, "enter "+name)
+					return nil
+				}),
+				OnExit(func(context.Context, interface{}) error {
+					calls = append(calls, "exit "+name)
+					return nil
+				}),
+			)
+		}
+		m := NewMachine(
+			WithTransitions(
+				states["Idle"].When("start", strIs("start")).Then(states["Active"]),
+				states["Picking"].When("picked", strIs("picked")).Then(states["Packing"]),
+				states["Active"].When("restart", strIs("restart")).Then(states["Active"]),
+				states["Active"].When("cancel", strIs("cancel")).Then(states["Cancelled"]),
+			),
+			WithSubstates(states["Active"], states["Picking"], states["Packing"]),
+		)
+
+		for _, tc := range []struct {
+			input string
+			want  []string
+		}{
+			{"start", []string{"exit Idle", "enter Active", "enter Picking"}},
+			{"picked", []string{"exit Picking", "enter Packing"}},
+			{"restart", []string{"exit Packing", "exit Active", "enter Active", "enter Picking"}},
+			{"cancel", []string{"exit Picking", "exit Active", "enter Cancelled"}},
+		} {
+			calls = nil
+			if _, err := m.Update(ctx, tc.input); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(calls, tc.want) {
+				t.Fatalf("%s: expected %v, got %v", tc.input, tc.want, calls)
+			}
+		}
+	})
+
+	t.Run("innermost transition wins", func(t *testing.T) {
+		parent := NewState("parent")
+		child := NewState("child")
+		inner := NewState("inner")
+		outer := NewState("outer")
+		m := NewMachine(
+			WithTransitions(
+				parent.When("x", strIs("x")).Then(outer),
+				child.When("x", strIs("x")).Then(inner),
+			),
+			WithSubstates(parent, child),
+		)
+		if _, err := m.Update(ctx, "x"); err != nil {
+			t.Fatal(err)
+		}
+		if m.Current().Name() != "inner" {
+			t.Fatalf("expected inner, got %s", m.Current().Name())
+		}
+	})
+
+	t.Run("composite start state", func(t *testing.T) {
+		m, _ := orderMachine(t)
+		if err := m.SetStart("Active"); err != nil {
+			t.Fatal(err)
+		}
+		if m.Current().Name() != "Picking" {
+			t.Fatalf("expected Picking, got %s", m.Current().Name())
+		}
+	})
+
+	t.Run("composite end state", func(t *testing.T) {
+		m, _ := orderMachine(t)
+		if err := m.SetEndStates("Active"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Update(ctx, "start"); err != nil {
+			t.Fatal(err)
+		}
+		if !m.IsEndState() {
+			t.Fatal("expected end state")
+		}
+	})
+
+	t.Run("invalid nesting panics", func(t *testing.T) {
+		a := NewState("a")
+		b := NewState("b")
+		c := NewState("c")
+		for name, f := range map[string]func(){
+			"no children":  func() { NewMachine(WithSubstates(a)) },
+			"two parents":  func() { NewMachine(WithSubstates(a, c), WithSubstates(b, c)) },
+			"cycle":        func() { NewMachine(WithSubstates(a, b), WithSubstates(b, a)) },
+			"nil children": func() { NewMachine(WithSubstates(a, nil)) },
+		} {
+			f := f
+			t.Run(name, func(t *testing.T) {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Fatal("panic expected")
+					}
+				}()
+				f()
+			})
+		}
+	})
+}
+
+func TestHierarchyAnalyze(t *testing.T) {
+	m, states := orderMachine(t)
+	r := m.Analyze()
+	if len(r.Unreachable)+len(r.DeadEnds)+len(r.UnreachableEnds) != 0 {
+		t.Fatalf("unexpected findings: %+v", r)
+	}
+
+	// a substate that is neither initial nor targeted is unreachable
+	lost := NewState("Lost")
+	m.AddSubstates(states["Active"], lost)
+	if got := names(m.Analyze().Unreachable); !reflect.DeepEqual(got, []string{"Lost"}) {
+		t.Fatalf("unexpected unreachable states: %v", got)
+	}
+	// but it is not a dead end, since Active can be cancelled
+	if got := m.Analyze().DeadEnds; len(got) != 0 {
+		t.Fatalf("unexpected dead ends: %v", names(got))
+	}
+}
+
+func TestHierarchyGraphs(t *testing.T) {
+	m, _ := orderMachine(t)
+	if _, err := m.Update(context.Background(), "start"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := m.PlantUML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `@startuml
+state "Idle" as S0
+state "Active" as S1 {
+  state "Picking" as S2 #lightgrey
+  state "Packing" as S3
+  state "Shipping" as S4
+  [*] --> S2
+}
+state "Cancelled" as S5
+state "Delivered" as S6
+[*] --> S0
+S0 --> S1 : start
+S1 --> S5 : cancel
+S1 --> S1 : restart
+S2 --> S3 : picked
+S3 --> S4 : packed
+S4 --> S6 : delivered
+S5 --> [*]
+S6 --> [*]
+@enduml
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected diagram:\n%s", got)
+	}
+
+	buf.Reset()
+	if err := m.Mermaid(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"    state \"Active\" as s1\n    state s1 {\n        state \"Picking\" as s2\n",
+		"        [*] --> s2\n    }\n",
+		"    class s2 current\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in diagram:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := m.Graph(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\tcompound=true;\n",
+		"\tsubgraph cluster_n1 {\n\t\tlabel=\"Active\";\n\t\tn2 [label=\"Picking\", style=filled, fillcolor=lightgrey];\n",
+		"\tn0 -> n2 [label=\"start\", lhead=cluster_n1];\n",
+		"\tn2 -> n5 [label=\"cancel\", ltail=cluster_n1];\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in graph:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package fsm
+
+// history is a pseudo-state standing for the most recently active substate
+// of a composite state.  A shallow history resumes the direct child that was
+// last active, entering its initial substates; a deep history resumes the
+// exact leaf state that was last active.
+type history struct {
+	id     uint64
+	parent State
+	deep   bool
+}
+
+// History returns a shallow history pseudo-state for parent.  A transition
+// targeting it enters the substate of parent that was active when parent was
+// last exited, or the initial substate if parent has not been exited since
+// the machine was created or reset.
+func History(parent State) State {
+	return &history{id: mkID("history", parent.Name()), parent: parent}
+}
+
+// DeepHistory returns a deep history pseudo-state for parent.  A transition
+// targeting it re-enters the leaf state that was active when parent was last
+// exited, or the initial substate if parent has not been exited since the
+// machine was created or reset.
+func DeepHistory(parent State) State {
+	return &history{id: mkID("deep history", parent.Name()), parent: parent, deep: true}
+}
+
+func (h *history) Id() uint64 {
+	return h.id
+}
+
+func (h *history) Name() string {
+	if h.deep {
+		return "H*(" + h.parent.Name() + ")"
+	}
+	return "H(" + h.parent.Name() + ")"
+}
+
+func (h *history) When(desc string, f TriggerFunc) Transition {
+	return &edge{id: mkID(h.Name(), desc), from: h, f: f, desc: desc}
+}
+
+// anchor returns the real state that s stands for: the parent of a history
+// pseudo-state, or s itself
+func anchor(s State) State {
+	if h, ok := s.(*history); ok {
+		return h.parent
+	}
+	return s
+}
+
+func isPseudo(s State) bool {
+	_, ok := s.(*history)
+	return ok
+}
+
+// resume returns the state a transition to s actually enters, before any
+// initial substates are followed.  For history pseudo-states this is the
+// recorded substate of the parent.  The caller must hold m.mu.
+func (m *machine) resume(s State) State {
+	h, ok := s.(*history)
+	if !ok {
+		return s
+	}
+
+	leaf, ok := m.history[h.parent.Id()]
+	if !ok {
+		return h.parent
+	}
+	if h.deep {
+		return leaf
+	}
+
+	// the direct child of the parent on the way to the recorded leaf
+	for _, a := range m.ancestors(leaf) {
+		if p, ok := m.parents[a.Id()]; ok && p.Id() == h.parent.Id() {
+			return a
+		}
+	}
+	return h.parent
+}
+
+// remember records curr as the last active leaf of every composite state in
+// exits.  The caller must hold m.mu.
+func (m *machine) remember(curr State, exits []State) {
+	for _, s := range exits {
+		if len(m.children[s.Id()]) == 0 {
+			continue
+		}
+		if m.history == nil {
+			m.history = make(map[uint64]State)
+		}
+		m.history[s.Id()] = curr
+	}
+}
@@ -0,0 +1,156 @@
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pausable builds a machine where Active contains Picking and Packing, and
+// Packing contains Wrapping and Boxing.  Active can be paused and resumed
+// through its shallow or deep history.
+func pausable(t *testing.T) *machine {
+	t.Helper()
+
+	var (
+		active   = NewState("Active")
+		picking  = NewState("Picking")
+		packing  = NewState("Packing")
+		wrapping = NewState("Wrapping")
+		boxing   = NewState("Boxing")
+		paused   = NewState("Paused")
+	)
+
+	return NewMachine(
+		WithTransitions(
+			active.When("pause", strIs("pause")).Then(paused),
+			picking.When("picked", strIs("picked")).Then(packing),
+			wrapping.When("wrapped", strIs("wrapped")).Then(boxing),
+			paused.When("resume", strIs("resume")).Then(History(active)),
+			paused.When("resume deep", strIs("resume deep")).Then(DeepHistory(active)),
+		),
+		WithSubstates(active, picking, packing),
+		WithSubstates(packing, wrapping, boxing),
+	)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		inputs []string
+		want   []string
+	}{
+		{
+			name:   "shallow without history enters initial substate",
+			inputs: []string{"pause", "resume"},
+			want:   []string{"Active", "Picking"},
+		},
+		{
+			name:   "deep without history enters initial substate",
+			inputs: []string{"pause", "resume deep"},
+			want:   []string{"Active", "Picking"},
+		},
+		{
+			name:   "shallow resumes last child",
+			inputs: []string{"picked", "wrapped", "pause", "resume"},
+			want:   []string{"Active", "Packing", "Wrapping"},
+		},
+		{
+			name:   "deep resumes last leaf",
+			inputs: []string{"picked", "wrapped", "pause", "resume deep"},
+			want:   []string{"Active", "Packing", "Boxing"},
+		},
+		{
+			name:   "history is updated on every exit",
+			inputs: []string{"picked", "wrapped", "pause", "resume", "pause", "resume deep"},
+			want:   []string{"Active", "Packing", "Wrapping"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			m := pausable(t)
+			if err := m.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			for _, input := range tt.inputs {
+				if changed, err := m.Update(ctx, input); err != nil || !changed {
+					t.Fatalf("%s: changed=%v err=%v", input, changed, err)
+				}
+			}
+			if got := names(m.CurrentPath()); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("reset clears history", func(t *testing.T) {
+		m := pausable(t)
+		for _, input := range []string{"picked", "wrapped"} {
+			if _, err := m.Update(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range []string{"pause", "resume deep"} {
+			if _, err := m.Update(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := names(m.CurrentPath()); !reflect.DeepEqual(got, []string{"Active", "Picking"}) {
+			t.Fatalf("unexpected path: %v", got)
+		}
+	})
+
+	t.Run("history of a leaf state is invalid", func(t *testing.T) {
+		a := NewState("a")
+		b := NewState("b")
+		m := NewMachine(WithTransitions(
+			a.When("x", strIs("x")).Then(b),
+			b.When("y", strIs("y")).Then(History(a)),
+		))
+		if err := m.Validate(); err == nil {
+			t.Fatal("expected invalid machine")
+		}
+	})
+}
+
+func TestHistoryGraphs(t *testing.T) {
+	m := pausable(t)
+
+	var buf bytes.Buffer
+	if err := m.PlantUML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"S3 --> S0[H] : resume\n", "S3 --> S0[H*] : resume deep\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in diagram:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := m.Mermaid(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"        state \"H\" as s0_h\n", "    s3 --> s0_hd : resume deep\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in diagram:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := m.Graph(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"\t\tn0_hd [label=\"H*\"];\n", "\tn3 -> n0_h [label=\"resume\"];\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in graph:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+)
+
+// Commit describes a change of state about to be made by a machine.  Its
+// slices have one entry for every region, starting with the main region.
+type Commit struct {
+	// Value is the value passed to Update, or the firing time for timed
+	// transitions
+	Value interface{}
+	// From holds the current leaf state of every region
+	From []State
+	// To holds the leaf state every region moves to
+	To []State
+	// Transitions holds the transition taken by every region, or nil for the
+	// regions that stay where they are
+	Transitions []Transition
+}
+
+// Interceptor wraps behaviour around a machine's guards and commits, much
+// like a gRPC unary interceptor.  Either function may be nil.
+//
+// Guard is called in place of every trigger function evaluated by Update,
+// and calls next to evaluate it.  Commit is called once for every change of
+// state, and calls next to run the actions of the transitions; the machine
+// only moves if Commit returns nil.  Neither may call back into the machine.
+type Interceptor struct {
+	Guard  func(ctx context.Context, t Transition, value interface{}, next TriggerFunc) (bool, error)
+	Commit func(ctx context.Context, c Commit, next func(context.Context) error) error
+}
+
+// WithInterceptors adds interceptors to the machine.  The first interceptor
+// is the outermost: it is called first and its call to next reaches the
+// second, and so on.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.interceptors = append(m.interceptors, interceptors...)
+	}
+}
+
+// guard evaluates the trigger function of t through the guard interceptors.
+// Errors and panics are returned as a GuardError.  The caller must hold m.mu.
+func (m *machine) guard(ctx context.Context, t Transition, value interface{}) (ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok, err = false, &GuardError{Transition: t, Err: &PanicError{Value: r}}
+		}
+	}()
+
+	ok, err = m.guardFrom(ctx, 0, t, value)
+	if err != nil {
+		var ge *GuardError
+		if !errors.As(err, &ge) {
+			return false, &GuardError{Transition: t, Err: err}
+		}
+	}
+	return ok, err
+}
+
+// guardFrom evaluates the trigger function of t through the guard
+// interceptors from the ith on.  Without any, it calls t.Go directly, so that
+// machines without guard interceptors allocate nothing here.
+func (m *machine) guardFrom(ctx context.Context, i int, t Transition, value interface{}) (bool, error) {
+	for ; i < len(m.interceptors); i++ {
+		if f := m.interceptors[i].Guard; f != nil {
+			rest := i + 1
+			return f(ctx, t, value, func(ctx context.Context, v interface{}) (bool, error) {
+				return m.guardFrom(ctx, rest, t, v)
+			})
+		}
+	}
+	return t.Go(ctx, value)
+}
+
+// intercept runs apply through the commit interceptors.  The caller must hold
+// m.mu.
+func (m *machine) intercept(ctx context.Context, c Commit, apply func(context.Context) error) error {
+	next := apply
+	for i := len(m.interceptors) - 1; i >= 0; i-- {
+		f, inner := m.interceptors[i].Commit, next
+		if f == nil {
+			continue
+		}
+		next = func(ctx context.Context) error {
+			return f(ctx, c, inner)
+		}
+	}
+	return next(ctx)
+}
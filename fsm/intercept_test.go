@@ -0,0 +1,168 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type (
+	userKey    struct{}
+	requestKey struct{}
+)
+
+func TestInterceptors(t *testing.T) {
+	ctx := context.Background()
+
+	var calls []string
+	logging := func(name string) Interceptor {
+		return Interceptor{
+			Guard: func(ctx context.Context, t Transition, v interface{}, next TriggerFunc) (bool, error) {
+				ok, err := next(ctx, v)
+				calls = append(calls, fmt.Sprintf("%s guard %s=%v", name, t.Description(), ok))
+				return ok, err
+			},
+			Commit: func(ctx context.Context, c Commit, next func(context.Context) error) error {
+				calls = append(calls, fmt.Sprintf("%s before %s", name, names(c.To)))
+				err := next(ctx)
+				calls = append(calls, fmt.Sprintf("%s after", name))
+				return err
+			},
+		}
+	}
+	action := func(ctx context.Context, _ interface{}) error {
+		calls = append(calls, "action")
+		return nil
+	}
+
+	t.Run("chain", func(t *testing.T) {
+		calls = nil
+		a := NewState("a")
+		b := NewState("b", OnEnter(action))
+		m := NewMachine(
+			WithTransitions(
+				a.When("y", strIs("y")).Then(a),
+				a.When("x", strIs("x")).Then(b),
+			),
+			WithInterceptors(logging("outer"), Interceptor{}, logging("inner")),
+		)
+
+		if _, err := m.Update(ctx, "x"); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"inner guard y=false",
+			"outer guard y=false",
+			"inner guard x=true",
+			"outer guard x=true",
+			"outer before [b]",
+			"inner before [b]",
+			"action",
+			"inner after",
+			"outer after",
+		}
+		if !reflect.DeepEqual(calls, want) {
+			t.Fatalf("expected %v, got %v", want, calls)
+		}
+	})
+
+	t.Run("guards can be overridden", func(t *testing.T) {
+		a := NewState("a")
+		b := NewState("b")
+		m := NewMachine(
+			WithTransitions(a.When("x", strIs("x")).Then(b)),
+			WithInterceptors(Interceptor{
+				Guard: func(ctx context.Context, t Transition, v interface{}, next TriggerFunc) (bool, error) {
+					if ctx.Value(userKey{}) == nil {
+						return false, nil
+					}
+					return next(ctx, v)
+				},
+			}),
+		)
+
+		if changed, err := m.Update(ctx, "x"); changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+		if changed, err := m.Update(context.WithValue(ctx, userKey{}, "admin"), "x"); !changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+	})
+
+	t.Run("commits can be vetoed", func(t *testing.T) {
+		errVeto := errors.New("vetoed")
+		a := NewState("a")
+		b := NewState("b")
+		m := NewMachine(
+			WithExtendedState(Data{"n": 0}),
+			WithTransitions(Do(a.When("x", strIs("x")), func(ctx context.Context, _ interface{}) error {
+				SetData(ctx, "n", 1)
+				return nil
+			}).Then(b)),
+			WithInterceptors(Interceptor{
+				Commit: func(ctx context.Context, c Commit, next func(context.Context) error) error {
+					if err := next(ctx); err != nil {
+						return err
+					}
+					return errVeto
+				},
+			}),
+		)
+
+		if changed, err := m.Update(ctx, "x"); changed || !errors.Is(err, errVeto) {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+		if m.Current().Name() != "a" || m.ExtendedState()["n"] != 0 {
+			t.Fatalf("unexpected state %s with %v", m.Current().Name(), m.ExtendedState())
+		}
+	})
+
+	t.Run("context reaches actions", func(t *testing.T) {
+		var got interface{}
+		a := NewState("a")
+		b := NewState("b", OnEnter(func(ctx context.Context, _ interface{}) error {
+			got = ctx.Value(requestKey{})
+			return nil
+		}))
+		m := NewMachine(
+			WithTransitions(a.When("x", strIs("x")).Then(b)),
+			WithInterceptors(Interceptor{
+				Commit: func(ctx context.Context, c Commit, next func(context.Context) error) error {
+					return next(context.WithValue(ctx, requestKey{}, 42))
+				},
+			}),
+		)
+
+		if _, err := m.Update(ctx, "x"); err != nil {
+			t.Fatal(err)
+		}
+		if got != 42 {
+			t.Fatalf("unexpected context value: %v", got)
+		}
+	})
+}
+
+func TestGuardAllocs(t *testing.T) {
+	ctx := context.Background()
+	a, b := NewState("a"), NewState("b")
+	tr := a.When("x", byteIs('x')).Then(b)
+	m := NewMachine(
+		WithTransitions(tr),
+		WithInterceptors(Interceptor{
+			Commit: func(ctx context.Context, c Commit, next func(context.Context) error) error {
+				return next(ctx)
+			},
+		}),
+	)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if ok, err := m.guard(ctx, tr, byte('x')); !ok || err != nil {
+			t.Fatalf("unexpected result: %v %v", ok, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations without guard interceptors, got %v", allocs)
+	}
+}
@@ -0,0 +1,316 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// automaton is the complete deterministic automaton described by a machine
+// made of symbol transitions.  States are numbered in breadth-first order
+// from the start state, which is 0, and the last state is a dead state that
+// every missing transition leads to.
+type automaton struct {
+	states  []State
+	symbols []interface{}
+	next    [][]int
+	accept  []bool
+}
+
+func (a *automaton) dead() int {
+	return len(a.states)
+}
+
+// symbolic returns the automaton of m, determinizing it first if it is an
+// NFA machine
+func (m *machine) symbolic() (*automaton, error) {
+	m.mu.RLock()
+	nondeterministic := m.nondeterministic
+	m.mu.RUnlock()
+
+	if nondeterministic {
+		d, err := m.Determinize()
+		if err != nil {
+			return nil, err
+		}
+		m = d
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.automaton()
+}
+
+// automaton builds the automaton of a deterministic machine.  Like Update,
+// it takes the first transition out of a state that accepts a symbol.  The
+// caller must hold m.mu.
+func (m *machine) automaton() (*automaton, error) {
+	if m.start == nil {
+		return nil, ErrNoStartState
+	}
+	if len(m.children) > 0 || len(m.regions) > 0 {
+		return nil, errors.New("machine has substates or regions")
+	}
+
+	a := &automaton{}
+	index := make(map[uint64]int)
+	column := make(map[interface{}]int)
+	var targets []map[interface{}]State
+
+	visit := func(s State) {
+		if _, ok := index[s.Id()]; !ok {
+			index[s.Id()] = len(a.states)
+			a.states = append(a.states, s)
+		}
+	}
+	visit(m.start)
+	for i := 0; i < len(a.states); i++ {
+		s := a.states[i]
+		out := make(map[interface{}]State)
+		for _, t := range m.transitions[s.Id()] {
+			sym, ok := symbolOf(t)
+			if !ok {
+				return nil, fmt.Errorf("transition '%s' from '%s' is not keyed on a symbol", t.Description(), s.Name())
+			}
+			if _, ok := column[sym]; !ok {
+				column[sym] = len(a.symbols)
+				a.symbols = append(a.symbols, sym)
+			}
+			if _, ok := out[sym]; !ok {
+				out[sym] = t.To()
+				visit(t.To())
+			}
+		}
+		targets = append(targets, out)
+		a.accept = append(a.accept, m.isFinal(s))
+	}
+
+	dead := a.dead()
+	a.next = make([][]int, dead+1)
+	for i := range a.next {
+		a.next[i] = make([]int, len(a.symbols))
+		for c, sym := range a.symbols {
+			a.next[i][c] = dead
+			if i == dead {
+				continue
+			}
+			if to, ok := targets[i][sym]; ok {
+				a.next[i][c] = index[to.Id()]
+			}
+		}
+	}
+	a.accept = append(a.accept, false)
+
+	return a, nil
+}
+
+// partition groups the states of a into classes of states that accept the
+// same sequences, using Hopcroft's algorithm, and returns the class of every
+// state
+func (a *automaton) partition() []int {
+	n := len(a.next)
+	inverse := make([][][]int, len(a.symbols))
+	for c := range a.symbols {
+		inverse[c] = make([][]int, n)
+		for q := 0; q < n; q++ {
+			to := a.next[q][c]
+			inverse[c][to] = append(inverse[c][to], q)
+		}
+	}
+
+	class := make([]int, n)
+	var blocks [][]int
+	var accepting, rejecting []int
+	for q := 0; q < n; q++ {
+		if a.accept[q] {
+			accepting = append(accepting, q)
+		} else {
+			rejecting = append(rejecting, q)
+		}
+	}
+	for _, b := range [][]int{rejecting, accepting} {
+		if len(b) == 0 {
+			continue
+		}
+		for _, q := range b {
+			class[q] = len(blocks)
+		}
+		blocks = append(blocks, b)
+	}
+
+	var work []int
+	pending := make(map[int]bool)
+	push := func(b int) {
+		work = append(work, b)
+		pending[b] = true
+	}
+	for b := range blocks {
+		push(b)
+	}
+
+	for len(work) > 0 {
+		splitter := append([]int(nil), blocks[work[0]]...)
+		delete(pending, work[0])
+		work = work[1:]
+
+		for c := range a.symbols {
+			touched := make(map[int][]int)
+			for _, q := range splitter {
+				for _, p := range inverse[c][q] {
+					touched[class[p]] = append(touched[class[p]], p)
+				}
+			}
+
+			order := make([]int, 0, len(touched))
+			for b := range touched {
+				order = append(order, b)
+			}
+			sort.Ints(order)
+			for _, b := range order {
+				in := touched[b]
+				if len(in) == len(blocks[b]) {
+					continue
+				}
+
+				moved := make(map[int]bool, len(in))
+				for _, p := range in {
+					moved[p] = true
+				}
+				var out []int
+				for _, p := range blocks[b] {
+					if !moved[p] {
+						out = append(out, p)
+					}
+				}
+
+				nb := len(blocks)
+				blocks = append(blocks, in)
+				blocks[b] = out
+				for _, p := range in {
+					class[p] = nb
+				}
+				if pending[b] || len(in) <= len(out) {
+					push(nb)
+				} else {
+					push(b)
+				}
+			}
+		}
+	}
+
+	return class
+}
+
+// Minimize returns the deterministic machine with the fewest states that
+// accepts the same sequences of symbols as m.  States that accept the same
+// sequences are merged into one, named after the first of them in
+// breadth-first order from the start state, and states that are unreachable
+// or cannot lead to an end state are dropped.  Transitions are keyed on the
+// same symbols as m's.  Actions and settings are not carried over.  NFA
+// machines are determinized first.  m must have a start state, no substates
+// or regions, and only transitions created with On, or Epsilon for an NFA.
+func (m *machine) Minimize() (*machine, error) {
+	a, err := m.symbolic()
+	if err != nil {
+		return nil, err
+	}
+	class := a.partition()
+	dead := class[a.dead()]
+
+	out := NewMachine()
+	out.transitions = make(map[uint64][]Transition)
+	out.endStates = make(map[uint64]State)
+
+	states := make(map[int]State)
+	var reps []int
+	for q, s := range a.states {
+		if _, ok := states[class[q]]; ok || (class[q] == dead && q != 0) {
+			continue
+		}
+		states[class[q]] = NewState(s.Name())
+		reps = append(reps, q)
+	}
+
+	out.start = states[class[0]]
+	for _, q := range reps {
+		from := states[class[q]]
+		if a.accept[q] {
+			out.endStates[from.Id()] = from
+		}
+		for c, sym := range a.symbols {
+			to := class[a.next[q][c]]
+			if to == dead {
+				continue
+			}
+			out.insert(On(from, sym).Then(states[to]))
+		}
+	}
+
+	return out, nil
+}
+
+// Equivalent reports whether a and b accept the same sequences of symbols.
+// If they do not, it also returns a shortest sequence that one of them
+// accepts and the other rejects.  NFA machines are determinized first.  Both
+// machines must have a start state, no substates or regions, and only
+// transitions created with On, or Epsilon for an NFA.
+func Equivalent(a, b *machine) (bool, []interface{}, error) {
+	x, err := a.symbolic()
+	if err != nil {
+		return false, nil, err
+	}
+	y, err := b.symbolic()
+	if err != nil {
+		return false, nil, err
+	}
+
+	var symbols []interface{}
+	seen := make(map[interface{}]bool)
+	for _, sym := range append(append([]interface{}(nil), x.symbols...), y.symbols...) {
+		if !seen[sym] {
+			seen[sym] = true
+			symbols = append(symbols, sym)
+		}
+	}
+	step := func(a *automaton, q int, sym interface{}) int {
+		for c, s := range a.symbols {
+			if s == sym {
+				return a.next[q][c]
+			}
+		}
+		return a.dead()
+	}
+
+	type pair struct{ x, y int }
+	type origin struct {
+		prev pair
+		sym  interface{}
+	}
+	start := pair{0, 0}
+	from := map[pair]origin{start: {}}
+	queue := []pair{start}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if x.accept[p.x] != y.accept[p.y] {
+			var seq []interface{}
+			for ; p != start; p = from[p].prev {
+				seq = append(seq, from[p].sym)
+			}
+			for i, j := 0, len(seq)-1; i < j; i, j = i+1, j-1 {
+				seq[i], seq[j] = seq[j], seq[i]
+			}
+			return false, seq, nil
+		}
+		for _, sym := range symbols {
+			next := pair{step(x, p.x, sym), step(y, p.y, sym)}
+			if _, ok := from[next]; !ok {
+				from[next] = origin{prev: p, sym: sym}
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return true, nil, nil
+}
@@ -0,0 +1,175 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// remainders is a machine over binary digits with one state for every
+// remainder modulo n, accepting the numbers whose remainder is in ends
+func remainders(t *testing.T, n int, ends ...string) *machine {
+	t.Helper()
+
+	states := make([]State, n)
+	for i := range states {
+		states[i] = NewState(fmt.Sprintf("r%d", i))
+	}
+	var transitions []Transition
+	for i, s := range states {
+		transitions = append(transitions,
+			On(s, '0').Then(states[(2*i)%n]),
+			On(s, '1').Then(states[(2*i+1)%n]),
+		)
+	}
+	m := NewMachine(WithStates(states...), WithTransitions(transitions...))
+	if err := m.SetEndStates(ends...); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// describeTransitions lists the transitions of m in breadth-first order
+func describeTransitions(m *machine) string {
+	var out []string
+	for _, s := range m.states() {
+		for _, t := range m.transitions[s.Id()] {
+			out = append(out, s.Name()+" "+t.Description()+" "+t.To().Name())
+		}
+	}
+	return strings.Join(out, "; ")
+}
+
+func TestMinimize(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("merges equivalent states", func(t *testing.T) {
+		// remainders modulo 6 that are multiples of 3 are remainders modulo 3
+		m := remainders(t, 6, "r0", "r3")
+		small, err := m.Minimize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := small.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		want := "r0 '0' r0; r0 '1' r1; r1 '0' r2; r1 '1' r0; r2 '0' r1; r2 '1' r2"
+		if got := describeTransitions(small); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+		if !small.isFinal(small.lookup("r0")) || small.isFinal(small.lookup("r1")) || small.isFinal(small.lookup("r2")) {
+			t.Fatal("unexpected end states")
+		}
+
+		for i := 0; i < 64; i++ {
+			ok, err := small.Accepts(ctx, Runes(fmt.Sprintf("%b", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (i%3 == 0) {
+				t.Fatalf("%b: expected %v", i, !ok)
+			}
+		}
+	})
+
+	t.Run("drops dead and unreachable states", func(t *testing.T) {
+		start, a, trap, island, done := NewState("start"), NewState("a"), NewState("trap"), NewState("island"), NewState("done")
+		m := NewMachine(WithTransitions(
+			On(start, 'a').Then(a),
+			On(start, 'x').Then(trap),
+			On(a, 'b').Then(done),
+			On(trap, 'a').Then(trap),
+			On(island, 'a').Then(done),
+		))
+		if err := m.SetEndStates("done"); err != nil {
+			t.Fatal(err)
+		}
+		small, err := m.Minimize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := describeTransitions(small), "start 'a' a; a 'b' done"; got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	})
+
+	t.Run("empty language", func(t *testing.T) {
+		a, b := NewState("a"), NewState("b")
+		small, err := NewMachine(WithTransitions(On(a, 1).Then(b), On(b, 1).Then(a))).Minimize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(small.states()); got != 1 || small.Current().Name() != "a" || describeTransitions(small) != "" {
+			t.Fatalf("unexpected machine: %s", describeTransitions(small))
+		}
+	})
+
+	t.Run("NFA", func(t *testing.T) {
+		small, err := thirdFromLast(t).Minimize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(small.states()); got != 8 {
+			t.Fatalf("expected 8 states, got %d", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		a, b := NewState("a"), NewState("b")
+		if _, err := NewMachine(WithTransitions(a.When("guard", byteIs('x')).Then(b))).Minimize(); err == nil {
+			t.Fatal("expected error")
+		}
+		if _, err := NewMachine().Minimize(); err != ErrNoStartState {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestEquivalent(t *testing.T) {
+	ctx := context.Background()
+
+	mod3 := remainders(t, 3, "r0")
+	for _, m := range []*machine{remainders(t, 6, "r0", "r3"), remainders(t, 9, "r0", "r3", "r6")} {
+		ok, seq, err := Equivalent(mod3, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || seq != nil {
+			t.Fatalf("expected equivalent machines, got counterexample %v", seq)
+		}
+	}
+
+	// 3 is a multiple of 3, but not of 6
+	ok, seq, err := Equivalent(mod3, remainders(t, 6, "r0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || !reflect.DeepEqual(seq, []interface{}{'1', '1'}) {
+		t.Fatalf("unexpected result: %v %v", ok, seq)
+	}
+	accepted, err := mod3.Accepts(ctx, Slice(seq))
+	if err != nil || !accepted {
+		t.Fatalf("expected the counterexample to be accepted: %v", err)
+	}
+
+	// the NFA and its minimal DFA
+	small, err := thirdFromLast(t).Minimize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, seq, err := Equivalent(thirdFromLast(t), small); !ok || err != nil {
+		t.Fatalf("ok=%v seq=%v err=%v", ok, seq, err)
+	}
+
+	// different alphabets
+	a, b := NewState("a"), NewState("b")
+	other := NewMachine(WithTransitions(On(a, 'x').Then(b)))
+	if err := other.SetEndStates("b"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, seq, err := Equivalent(mod3, other); ok || err != nil || len(seq) != 0 {
+		t.Fatalf("expected the empty sequence to tell them apart: ok=%v seq=%v err=%v", ok, seq, err)
+	}
+}
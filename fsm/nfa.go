@@ -0,0 +1,308 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WithNFA makes the machine nondeterministic.  An NFA machine is in a set of
+// states at once: Update follows every transition out of any of them that
+// accepts the value, not only the first, and then every epsilon transition
+// out of the states it arrives in.  States that no transition leads out of
+// drop out of the set.  If no transition accepts the value, the set is left
+// unchanged, as it is for a deterministic machine.  The machine is in an end
+// state if any state in the set is one.  NFA machines cannot have substates,
+// regions or timed transitions.
+func WithNFA() Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.nondeterministic = true
+	}
+}
+
+func isEpsilon(t Transition) bool {
+	e, ok := t.(interface{ Epsilon() bool })
+	return ok && e.Epsilon()
+}
+
+// symbolOf returns the symbol a transition is keyed on, if it was created
+// with On
+func symbolOf(t Transition) (interface{}, bool) {
+	if s, ok := t.(interface{ Symbol() (interface{}, bool) }); ok {
+		return s.Symbol()
+	}
+	return nil, false
+}
+
+// possible returns the states an NFA machine is in, in order of name.  Until
+// a value is accepted, these are the start state and every state reachable
+// from it by epsilon transitions.  The caller must hold m.mu.
+func (m *machine) possible() []State {
+	if m.set != nil {
+		return m.set
+	}
+	if m.start == nil {
+		return nil
+	}
+	set, _ := m.closure([]State{m.start})
+	return set
+}
+
+// currentStates returns the current leaf state of every region, or every
+// state an NFA machine is in.  The caller must hold m.mu.
+func (m *machine) currentStates() []State {
+	if m.nondeterministic {
+		return append([]State(nil), m.possible()...)
+	}
+	return m.active()
+}
+
+// closure returns states along with every state reachable from them by
+// epsilon transitions, in order of name, and the epsilon transitions that
+// lead to them.  The caller must hold m.mu.
+func (m *machine) closure(states []State) ([]State, []Transition) {
+	seen := make(map[uint64]bool)
+	var (
+		out   []State
+		taken []Transition
+	)
+	queue := append([]State(nil), states...)
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if seen[s.Id()] {
+			continue
+		}
+		seen[s.Id()] = true
+		out = append(out, s)
+		for _, t := range m.transitions[s.Id()] {
+			if isEpsilon(t) {
+				taken = append(taken, t)
+				queue = append(queue, t.To())
+			}
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Name() < out[j].Name()
+	})
+	return out, taken
+}
+
+// stepNFA moves an NFA machine along every transition out of its states that
+// accepts value, then along epsilon transitions.  States leaving the set are
+// exited, the transitions' actions run, and states joining the set are
+// entered, through the machine's commit interceptors; an error leaves the
+// set unchanged.  The result describes the first state of the set before and
+// after, and the first transition taken.  The caller must hold m.mu.
+func (m *machine) stepNFA(ctx context.Context, value interface{}) (Result, error) {
+	from := m.possible()
+	if len(from) == 0 {
+		return Result{}, ErrNoStartState
+	}
+
+	ext := &extended{base: m.extendedState()}
+	ctx = context.WithValue(ctx, dataKey{}, ext)
+
+	r := Result{From: from[0], To: from[0]}
+	var (
+		taken   []Transition
+		targets []State
+	)
+	for _, s := range from {
+		if m.hasTransitions(s) {
+			r.HasTransitions = true
+		}
+		for _, t := range m.tables[s.Id()].candidates(value) {
+			if isEpsilon(t) || timeoutOf(t) > 0 {
+				continue
+			}
+			ok, err := m.guard(ctx, t, value)
+			if err != nil {
+				return r, err
+			}
+			if ok {
+				taken = append(taken, t)
+				targets = append(targets, t.To())
+			}
+		}
+	}
+	if len(taken) == 0 {
+		return r, nil
+	}
+
+	to, eps := m.closure(targets)
+	taken = append(taken, eps...)
+	exits, enters := without(from, to), without(to, from)
+
+	apply := func(ctx context.Context) error {
+		for _, s := range exits {
+			if err := exit(ctx, s, value); err != nil {
+				return err
+			}
+		}
+		for _, t := range taken {
+			if err := act(ctx, t, value); err != nil {
+				return err
+			}
+		}
+		for _, s := range enters {
+			if err := enter(ctx, s, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	c := Commit{Value: value, From: from, To: to, Transitions: taken}
+	if err := m.intercept(ctx, c, apply); err != nil {
+		return r, err
+	}
+
+	now := m.now()
+	m.track(now, exits, enters)
+	m.set = to
+	m.setCurrent(to[0])
+	if ext.work != nil {
+		m.data = ext.work
+	}
+	if m.observed() {
+		for _, t := range taken {
+			m.queue = append(m.queue, TransitionEvent{
+				From:       t.From(),
+				To:         t.To(),
+				Transition: t,
+				Value:      value,
+				Time:       now,
+			})
+		}
+	}
+	m.notify()
+
+	r.Transition, r.To = taken[0], to[0]
+	return r, nil
+}
+
+// without returns the states in a that are not in b
+func without(a, b []State) []State {
+	in := make(map[uint64]bool, len(b))
+	for _, s := range b {
+		in[s.Id()] = true
+	}
+	var out []State
+	for _, s := range a {
+		if !in[s.Id()] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// validateKind checks that the transitions of the machine suit its kind:
+// epsilon transitions need an NFA machine, and NFA machines cannot have
+// timed transitions, substates or regions.  The caller must hold m.mu.
+func (m *machine) validateKind() error {
+	for _, s := range m.states() {
+		for _, t := range m.transitions[s.Id()] {
+			if isEpsilon(t) && !m.nondeterministic {
+				return fmt.Errorf("epsilon transition from '%s' needs an NFA machine", s.Name())
+			}
+			if timeoutOf(t) > 0 && m.nondeterministic {
+				return fmt.Errorf("transition '%s' from '%s' is timed, which an NFA machine cannot be", t.Description(), s.Name())
+			}
+		}
+	}
+	if m.nondeterministic && (len(m.children) > 0 || len(m.regions) > 0) {
+		return errors.New("an NFA machine cannot have substates or regions")
+	}
+	return nil
+}
+
+// Determinize returns a deterministic machine that accepts the same sequences
+// of symbols as m does when run as an NFA.  Each of its states stands for a
+// set of states m can be in at once, and is named after them, as in {a,b}.
+// It is an end state if any of them is.  Its transitions are keyed on the
+// same symbols as m's; sets that no symbol leads out of have none.  Actions
+// and settings are not carried over.  m must have a start state, no
+// substates or regions, and only transitions created with On or Epsilon.
+func (m *machine) Determinize() (*machine, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.start == nil {
+		return nil, ErrNoStartState
+	}
+	if len(m.children) > 0 || len(m.regions) > 0 {
+		return nil, errors.New("cannot determinize a machine with substates or regions")
+	}
+	for _, s := range m.states() {
+		for _, t := range m.transitions[s.Id()] {
+			if _, ok := symbolOf(t); !ok && !isEpsilon(t) {
+				return nil, fmt.Errorf("cannot determinize transition '%s' from '%s': it is not keyed on a symbol", t.Description(), s.Name())
+			}
+		}
+	}
+
+	d := NewMachine()
+	d.transitions = make(map[uint64][]Transition)
+	d.endStates = make(map[uint64]State)
+
+	type pending struct {
+		set   []State
+		state State
+	}
+	var queue []pending
+	byName := make(map[string]State)
+	visit := func(set []State) State {
+		name := setName(set)
+		s, ok := byName[name]
+		if !ok {
+			s = NewState(name)
+			byName[name] = s
+			queue = append(queue, pending{set: set, state: s})
+		}
+		return s
+	}
+
+	start, _ := m.closure([]State{m.start})
+	d.start = visit(start)
+	for i := 0; i < len(queue); i++ {
+		p := queue[i]
+		var symbols []interface{}
+		targets := make(map[interface{}][]State)
+		for _, s := range p.set {
+			if m.isFinal(s) {
+				d.endStates[p.state.Id()] = p.state
+			}
+			for _, t := range m.transitions[s.Id()] {
+				sym, ok := symbolOf(t)
+				if !ok {
+					continue
+				}
+				if _, seen := targets[sym]; !seen {
+					symbols = append(symbols, sym)
+				}
+				targets[sym] = append(targets[sym], t.To())
+			}
+		}
+		for _, sym := range symbols {
+			next, _ := m.closure(targets[sym])
+			d.insert(On(p.state, sym).Then(visit(next)))
+		}
+	}
+
+	return d, nil
+}
+
+// setName names the state of a determinized machine standing for set
+func setName(set []State) string {
+	names := make([]string, len(set))
+	for i, s := range set {
+		names[i] = s.Name()
+	}
+	return "{" + strings.Join(names, ",") + "}"
+}
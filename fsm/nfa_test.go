@@ -0,0 +1,364 @@
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// thirdFromLast is an NFA accepting strings of a and b whose third symbol
+// from the end is a.  Its smallest equivalent DFA has 8 states.
+func thirdFromLast(t *testing.T) *machine {
+	t.Helper()
+
+	q0, q1, q2, q3 := NewState("q0"), NewState("q1"), NewState("q2"), NewState("q3")
+	m := NewMachine(WithNFA(), WithTransitions(
+		On(q0, 'a').Then(q0),
+		On(q0, 'b').Then(q0),
+		On(q0, 'a').Then(q1),
+		On(q1, 'a').Then(q2),
+		On(q1, 'b').Then(q2),
+		On(q2, 'a').Then(q3),
+		On(q2, 'b').Then(q3),
+	))
+	if err := m.SetEndStates("q3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// words returns every string of a and b up to length n
+func words(n int) []string {
+	out := []string{""}
+	for i, prev := 0, []string{""}; i < n; i++ {
+		var next []string
+		for _, w := range prev {
+			next = append(next, w+"a", w+"b")
+		}
+		out = append(out, next...)
+		prev = next
+	}
+	return out
+}
+
+// joined returns the names of states separated by commas
+func joined(states []State) string {
+	return strings.Join(names(states), ",")
+}
+
+func TestNFA(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("sets", func(t *testing.T) {
+		m := thirdFromLast(t)
+		for _, step := range []struct {
+			in   rune
+			want string
+		}{
+			{'a', "q0,q1"},
+			{'b', "q0,q2"},
+			{'a', "q0,q1,q3"},
+			{'b', "q0,q2"},
+		} {
+			ok, err := m.Update(ctx, step.in)
+			if err != nil || !ok {
+				t.Fatalf("%c: ok=%v err=%v", step.in, ok, err)
+			}
+			if got := joined(m.CurrentStates()); got != step.want {
+				t.Fatalf("%c: expected %s, got %s", step.in, step.want, got)
+			}
+		}
+		if m.Current().Name() != "q0" {
+			t.Fatalf("unexpected current state: %s", m.Current().Name())
+		}
+
+		// rejected values leave the set unchanged
+		if ok, err := m.Update(ctx, 'c'); ok || err != nil {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+		if got := joined(m.CurrentStates()); got != "q0,q2" {
+			t.Fatalf("unexpected states: %s", got)
+		}
+	})
+
+	t.Run("rejections", func(t *testing.T) {
+		// none of the states has a transition on 'c'
+		r, err := thirdFromLast(t).Step(ctx, 'c')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Changed() || !r.HasTransitions || !r.Rejected() {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+
+		// q3 has no transitions at all
+		q0, q3 := NewState("q0"), NewState("q3")
+		m := NewMachine(WithNFA(), WithTransitions(On(q0, 'a').Then(q3)))
+		if _, err := m.Update(ctx, 'a'); err != nil {
+			t.Fatal(err)
+		}
+		if r, err := m.Step(ctx, 'a'); err != nil || r.HasTransitions || r.Rejected() {
+			t.Fatalf("unexpected result: %+v (err=%v)", r, err)
+		}
+	})
+
+	t.Run("graphs highlight every state", func(t *testing.T) {
+		m := thirdFromLast(t)
+		for _, r := range "aba" {
+			if _, err := m.Update(ctx, r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := m.Mermaid(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "class s0,s1,s3 current") {
+			t.Fatalf("expected q0, q1 and q3 to be highlighted:\n%s", buf.String())
+		}
+	})
+
+	t.Run("accepts", func(t *testing.T) {
+		m := thirdFromLast(t)
+		for _, w := range words(7) {
+			got, err := m.Accepts(ctx, Runes(w))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := len(w) >= 3 && w[len(w)-3] == 'a'; got != want {
+				t.Fatalf("%q: expected %v", w, want)
+			}
+		}
+	})
+
+	t.Run("epsilon", func(t *testing.T) {
+		// a* or b*
+		start, as, bs := NewState("start"), NewState("as"), NewState("bs")
+		m := NewMachine(WithNFA(), WithTransitions(
+			Epsilon(start).Then(as),
+			Epsilon(start).Then(bs),
+			On(as, 'a').Then(as),
+			On(bs, 'b').Then(bs),
+		))
+		if err := m.SetEndStates("as", "bs"); err != nil {
+			t.Fatal(err)
+		}
+		if got := joined(m.CurrentStates()); got != "as,bs,start" {
+			t.Fatalf("unexpected states: %s", got)
+		}
+
+		for w, want := range map[string]bool{"": true, "aaa": true, "bb": true, "ab": false, "ba": false} {
+			got, err := m.Accepts(ctx, Runes(w))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("%q: expected %v", w, want)
+			}
+		}
+	})
+
+	t.Run("actions and events", func(t *testing.T) {
+		var log []string
+		record := func(what string) ActionFunc {
+			return func(context.Context, interface{}) error {
+				log = append(log, what)
+				return nil
+			}
+		}
+		a := NewState("a", OnExit(record("exit a")))
+		b := NewState("b", OnEnter(record("enter b")))
+		c := NewState("c", OnEnter(record("enter c")))
+		m := NewMachine(WithNFA(), WithTransitions(
+			Do(On(a, 1).Then(b), record("a-b")),
+			Do(On(a, 1).Then(a), record("a-a")),
+			Do(Epsilon(b).Then(c), record("b-c")),
+		))
+		var events []string
+		m.Subscribe(func(e TransitionEvent) {
+			events = append(events, e.From.Name()+"-"+e.To.Name())
+		})
+
+		if ok, err := m.Update(ctx, 1); !ok || err != nil {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+		if got := strings.Join(log, ", "); got != "a-b, a-a, b-c, enter b, enter c" {
+			t.Fatalf("unexpected actions: %s", got)
+		}
+		if got := strings.Join(events, ", "); got != "a-b, a-a, b-c" {
+			t.Fatalf("unexpected events: %s", got)
+		}
+		if got := joined(m.CurrentStates()); got != "a,b,c" {
+			t.Fatalf("unexpected states: %s", got)
+		}
+	})
+
+	t.Run("errors leave the set unchanged", func(t *testing.T) {
+		a, b := NewState("a"), NewState("b", OnEnter(func(context.Context, interface{}) error {
+			return errors.New("boom")
+		}))
+		m := NewMachine(WithNFA(), WithTransitions(On(a, 1).Then(b), On(a, 1).Then(a)))
+		if _, err := m.Update(ctx, 1); err == nil || err.Error() != "boom" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := joined(m.CurrentStates()); got != "a" {
+			t.Fatalf("unexpected states: %s", got)
+		}
+	})
+
+	t.Run("snapshots", func(t *testing.T) {
+		m := thirdFromLast(t)
+		if _, err := m.Run(ctx, Runes("aab")); err != nil {
+			t.Fatal(err)
+		}
+		snap := m.Snapshot()
+		if got := strings.Join(snap.Current, ","); got != "q0,q2,q3" {
+			t.Fatalf("unexpected snapshot: %s", got)
+		}
+
+		other := thirdFromLast(t)
+		if err := other.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+		if got := joined(other.CurrentStates()); got != "q0,q2,q3" || !other.IsEndState() {
+			t.Fatalf("unexpected states: %s", got)
+		}
+
+		snap.Current = nil
+		if err := other.Restore(snap); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		a, b := NewState("a"), NewState("b")
+		if err := NewMachine(WithTransitions(Epsilon(a).Then(b))).Validate(); err == nil {
+			t.Fatal("deterministic machines must not have epsilon transitions")
+		}
+		if err := NewMachine(WithNFA(), WithTransitions(After(a, 1).Then(b))).Validate(); err == nil {
+			t.Fatal("NFA machines must not have timed transitions")
+		}
+		if err := NewMachine(WithNFA(), WithTransitions(On(a, 1).Then(b)), WithSubstates(b, NewState("c"))).Validate(); err == nil {
+			t.Fatal("NFA machines must not have substates")
+		}
+	})
+}
+
+func TestDeterminize(t *testing.T) {
+	ctx := context.Background()
+	nfa := thirdFromLast(t)
+
+	dfa, err := nfa.Determinize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dfa.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if dfa.nondeterministic {
+		t.Fatal("expected a deterministic machine")
+	}
+	if got := len(dfa.states()); got != 8 {
+		t.Fatalf("expected 8 states, got %d", got)
+	}
+	if got := dfa.Current().Name(); got != "{q0}" {
+		t.Fatalf("unexpected start state: %s", got)
+	}
+
+	for _, w := range words(8) {
+		want, err := nfa.Accepts(ctx, Runes(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := dfa.Accepts(ctx, Runes(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%q: expected %v", w, want)
+		}
+	}
+
+	t.Run("epsilon", func(t *testing.T) {
+		start, as, bs := NewState("start"), NewState("as"), NewState("bs")
+		m := NewMachine(WithNFA(), WithTransitions(
+			Epsilon(start).Then(as),
+			Epsilon(start).Then(bs),
+			On(as, 'a').Then(as),
+			On(bs, 'b').Then(bs),
+		))
+		if err := m.SetEndStates("as"); err != nil {
+			t.Fatal(err)
+		}
+		d, err := m.Determinize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var desc []string
+		for _, s := range d.states() {
+			for _, tr := range d.transitions[s.Id()] {
+				desc = append(desc, s.Name()+" "+tr.Description()+" "+tr.To().Name())
+			}
+		}
+		if got, want := strings.Join(desc, "; "), "{as,bs,start} 'a' {as}; {as,bs,start} 'b' {bs}; {as} 'a' {as}; {bs} 'b' {bs}"; got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+		for _, name := range []string{"{as,bs,start}", "{as}"} {
+			if !d.isFinal(d.lookup(name)) {
+				t.Fatalf("expected %s to be an end state", name)
+			}
+		}
+		if d.isFinal(d.lookup("{bs}")) {
+			t.Fatal("{bs} must not be an end state")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		a, b := NewState("a"), NewState("b")
+		if _, err := NewMachine().Determinize(); !errors.Is(err, ErrNoStartState) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		guarded := NewMachine(WithTransitions(a.When("guard", byteIs('x')).Then(b)))
+		if _, err := guarded.Determinize(); err == nil || !strings.Contains(err.Error(), "'guard'") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestOn(t *testing.T) {
+	a, b := NewState("a"), NewState("b")
+	for want, tr := range map[string]Transition{
+		"'a'":  On(a, 'a'),
+		"'x'":  On(a, byte('x')),
+		"open": On(a, "open"),
+		"42":   On(a, 42),
+	} {
+		if tr.Description() != want {
+			t.Fatalf("expected %s, got %s", want, tr.Description())
+		}
+	}
+
+	ok, err := On(a, 'a').Then(b).Go(context.Background(), 'a')
+	if !ok || err != nil {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+	if ok, _ := On(a, 'a').Go(context.Background(), "a"); ok {
+		t.Fatal("symbols of different types must not match")
+	}
+	if ok, _ := On(a, 'a').Go(context.Background(), []int{1}); ok {
+		t.Fatal("uncomparable values must not match")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	On(a, []int{1})
+}
@@ -0,0 +1,143 @@
+package fsm
+
+import (
+	"sync"
+	"time"
+)
+
+// TransitionEvent describes a transition taken by a machine
+type TransitionEvent struct {
+	// Region is the name of the region that took the transition, or empty for
+	// the main region
+	Region string
+	// From is the leaf state the region was in
+	From State
+	// To is the leaf state the region is in now
+	To State
+	// Transition is the transition taken
+	Transition Transition
+	// Value is the value passed to Update, or the firing time for timed
+	// transitions
+	Value interface{}
+	// Time is when the transition was committed, according to the machine's
+	// clock
+	Time time.Time
+}
+
+// Observer receives transition events.  Observers are called one at a time,
+// in the order the transitions were committed, after the machine has been
+// unlocked: they may read the machine, and Update calls they make are
+// delivered once they return.
+type Observer func(TransitionEvent)
+
+type subscriber struct {
+	f Observer
+}
+
+// WithObserver adds an observer that is called for every transition the
+// machine takes
+func WithObserver(f Observer) Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.observers = append(m.observers, f)
+	}
+}
+
+// Subscribe adds an observer that is called for every transition the machine
+// takes, until the returned function is called
+func (m *machine) Subscribe(f Observer) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub := &subscriber{f: f}
+	m.subscribers = append(m.subscribers, sub)
+
+	return func() {
+		m.unsubscribe(sub)
+	}
+}
+
+// Events returns a channel that receives every transition the machine takes,
+// buffering up to size events.  When the buffer is full, the machine waits
+// for the channel to be read before delivering further events.  The returned
+// function stops delivery and closes the channel; it must not be called from
+// an observer.
+func (m *machine) Events(size int) (<-chan TransitionEvent, func()) {
+	ch := make(chan TransitionEvent, size)
+	stop := make(chan struct{})
+	unsubscribe := m.Subscribe(func(e TransitionEvent) {
+		select {
+		case ch <- e:
+		case <-stop:
+		}
+	})
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			close(stop)
+			unsubscribe()
+
+			// wait for any delivery in progress before closing
+			m.pub.Lock()
+			defer m.pub.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (m *machine) unsubscribe(sub *subscriber) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, s := range m.subscribers {
+		if s == sub {
+			m.subscribers = append(m.subscribers[:i:i], m.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// observed reports whether anything is listening for transitions.  The
+// caller must hold m.mu.
+func (m *machine) observed() bool {
+	return len(m.observers) > 0 || len(m.subscribers) > 0
+}
+
+// publish delivers queued events to the observers.  It must be called
+// without holding m.mu.  If another call is already delivering, that call
+// picks up the queued events instead.
+func (m *machine) publish() {
+	for {
+		if !m.pub.TryLock() {
+			return
+		}
+
+		m.mu.Lock()
+		events := m.queue
+		m.queue = nil
+		observers := append([]Observer(nil), m.observers...)
+		for _, s := range m.subscribers {
+			observers = append(observers, s.f)
+		}
+		m.mu.Unlock()
+
+		for _, e := range events {
+			for _, f := range observers {
+				f(e)
+			}
+		}
+		m.pub.Unlock()
+
+		// events queued while delivering would otherwise wait for the next
+		// transition
+		m.mu.RLock()
+		pending := len(m.queue) > 0
+		m.mu.RUnlock()
+		if !pending {
+			return
+		}
+	}
+}
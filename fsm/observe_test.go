@@ -0,0 +1,175 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// describe renders an event for comparison
+func describe(e TransitionEvent) string {
+	s := e.From.Name() + " -" + e.Transition.Description() + "-> " + e.To.Name()
+	if e.Region != "" {
+		s = e.Region + ": " + s
+	}
+	return s
+}
+
+func TestObservers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("synchronous", func(t *testing.T) {
+		clock := newManualClock()
+		var events []TransitionEvent
+		m := orderStatus(t)
+		WithClock(clock)(m)
+		WithObserver(func(e TransitionEvent) {
+			events = append(events, e)
+		})(m)
+
+		for _, input := range []string{"ship", "pay", "nope"} {
+			if _, err := m.Update(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var got []string
+		for _, e := range events {
+			got = append(got, describe(e))
+			if !e.Time.Equal(clock.Now()) {
+				t.Fatalf("unexpected time: %v", e.Time)
+			}
+		}
+		want := []string{"fulfilment: pending -ship-> shipped", "unpaid -pay-> paid"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		if events[1].Value != "pay" {
+			t.Fatalf("unexpected value: %v", events[1].Value)
+		}
+	})
+
+	t.Run("observers can use the machine", func(t *testing.T) {
+		m := orderStatus(t)
+		var got []string
+		m.Subscribe(func(e TransitionEvent) {
+			got = append(got, describe(e)+" now "+m.Current().Name())
+			if e.Value == "pay" {
+				if _, err := m.Update(ctx, "ship"); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+
+		if _, err := m.Update(ctx, "pay"); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"unpaid -pay-> paid now paid", "fulfilment: pending -ship-> shipped now paid"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("failed transitions are not observed", func(t *testing.T) {
+		errAction := errors.New("action failed")
+		a := NewState("a")
+		b := NewState("b", OnEnter(func(context.Context, interface{}) error {
+			return errAction
+		}))
+		var calls int
+		m := NewMachine(
+			WithTransitions(a.When("x", strIs("x")).Then(b)),
+			WithObserver(func(TransitionEvent) { calls++ }),
+		)
+		if _, err := m.Update(ctx, "x"); !errors.Is(err, errAction) {
+			t.Fatalf("expected action error, got %v", err)
+		}
+		if calls != 0 {
+			t.Fatalf("unexpected calls: %d", calls)
+		}
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		m := orderStatus(t)
+		var calls int
+		unsubscribe := m.Subscribe(func(TransitionEvent) { calls++ })
+		if _, err := m.Update(ctx, "pay"); err != nil {
+			t.Fatal(err)
+		}
+		unsubscribe()
+		if _, err := m.Update(ctx, "ship"); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 1 {
+			t.Fatalf("expected 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("channel", func(t *testing.T) {
+		m := orderStatus(t)
+		events, cancel := m.Events(2)
+		for _, input := range []string{"pay", "ship"} {
+			if _, err := m.Update(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, want := range []string{"unpaid -pay-> paid", "fulfilment: pending -ship-> shipped"} {
+			if got := describe(<-events); got != want {
+				t.Fatalf("expected %s, got %s", want, got)
+			}
+		}
+
+		cancel()
+		cancel()
+		if _, ok := <-events; ok {
+			t.Fatal("expected closed channel")
+		}
+	})
+
+	t.Run("cancel unblocks delivery", func(t *testing.T) {
+		m := orderStatus(t)
+		_, cancel := m.Events(0)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if _, err := m.Update(ctx, "pay"); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("expected Update to wait for the channel")
+		case <-time.After(10 * time.Millisecond):
+		}
+		cancel()
+		<-done
+	})
+
+	t.Run("instances", func(t *testing.T) {
+		var got []string
+		m := orderStatus(t)
+		WithObserver(func(e TransitionEvent) {
+			got = append(got, describe(e))
+		})(m)
+		def, err := m.Define()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, b := def.NewInstance(), def.NewInstance()
+		var own int
+		b.Subscribe(func(TransitionEvent) { own++ })
+		for _, i := range []*Instance{a, b} {
+			if _, err := i.Update(ctx, "pay"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(got) != 2 || own != 1 {
+			t.Fatalf("unexpected events: %v, %d", got, own)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package regex
+
+import (
+	"fmt"
+	"sort"
+)
+
+// dfa is the deterministic automaton built from an NFA by subset
+// construction.  State 0 is the start state.
+type dfa struct {
+	states []dstate
+}
+
+type dstate struct {
+	accept bool
+	edges  []dedge
+}
+
+// dedge moves to state to on any rune in set
+type dedge struct {
+	set runeSet
+	to  int
+}
+
+// determinize builds the DFA equivalent to the fragment f of n.  Every DFA
+// state stands for the set of NFA nodes reachable on the same input, and its
+// edges are computed over the intervals between the bounds of the rune sets
+// leaving those nodes, so that each rune leads to exactly one state.
+func determinize(n *nfa, f frag) *dfa {
+	d := &dfa{}
+	ids := map[string]int{}
+	var sets [][]int
+
+	visit := func(nodes []int) int {
+		key := fmt.Sprint(nodes)
+		if id, ok := ids[key]; ok {
+			return id
+		}
+		id := len(sets)
+		ids[key] = id
+		sets = append(sets, nodes)
+		d.states = append(d.states, dstate{accept: contains(nodes, f.end)})
+		return id
+	}
+
+	visit(n.closure([]int{f.start}))
+	for id := 0; id < len(sets); id++ {
+		var bounds []rune
+		for _, i := range sets[id] {
+			for _, r := range n.nodes[i].set {
+				bounds = append(bounds, r.lo, r.hi+1)
+			}
+		}
+		bounds = unique(bounds)
+
+		ranges := map[int][]runeRange{}
+		var order []int
+		for b := 0; b+1 < len(bounds); b++ {
+			lo, hi := bounds[b], bounds[b+1]-1
+			var next []int
+			for _, i := range sets[id] {
+				if n.nodes[i].set.contains(lo) {
+					next = append(next, n.nodes[i].next)
+				}
+			}
+			if len(next) == 0 {
+				continue
+			}
+			to := visit(n.closure(next))
+			if _, ok := ranges[to]; !ok {
+				order = append(order, to)
+			}
+			ranges[to] = append(ranges[to], runeRange{lo, hi})
+		}
+
+		sort.Ints(order)
+		for _, to := range order {
+			d.states[id].edges = append(d.states[id].edges, dedge{set: normalize(ranges[to]), to: to})
+		}
+	}
+
+	return d
+}
+
+// closure returns the sorted set of nodes reachable from nodes without
+// consuming input
+func (n *nfa) closure(nodes []int) []int {
+	seen := map[int]bool{}
+	stack := append([]int(nil), nodes...)
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		stack = append(stack, n.nodes[i].eps...)
+	}
+
+	out := make([]int, 0, len(seen))
+	for i := range seen {
+		out = append(out, i)
+	}
+	sort.Ints(out)
+	return out
+}
+
+func contains(nodes []int, i int) bool {
+	j := sort.SearchInts(nodes, i)
+	return j < len(nodes) && nodes[j] == i
+}
+
+// unique sorts bounds and drops duplicates
+func unique(bounds []rune) []rune {
+	sort.Slice(bounds, func(i, j int) bool {
+		return bounds[i] < bounds[j]
+	})
+	out := bounds[:0]
+	for _, b := range bounds {
+		if len(out) > 0 && b == out[len(out)-1] {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
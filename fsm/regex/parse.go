@@ -0,0 +1,344 @@
+package regex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// nfa is a Thompson NFA.  Every node either consumes a rune in set to move to
+// next, or moves to the nodes in eps without consuming anything.
+type nfa struct {
+	nodes []node
+}
+
+type node struct {
+	set  runeSet
+	next int
+	eps  []int
+}
+
+// frag is a piece of an NFA with a single entry and a single exit node
+type frag struct {
+	start, end int
+}
+
+func (n *nfa) add() int {
+	n.nodes = append(n.nodes, node{next: -1})
+	return len(n.nodes) - 1
+}
+
+func (n *nfa) link(from int, to ...int) {
+	n.nodes[from].eps = append(n.nodes[from].eps, to...)
+}
+
+func (n *nfa) empty() frag {
+	s := n.add()
+	return frag{s, s}
+}
+
+func (n *nfa) set(set runeSet) frag {
+	s, e := n.add(), n.add()
+	n.nodes[s].set = set
+	n.nodes[s].next = e
+	return frag{s, e}
+}
+
+func (n *nfa) cat(f, g frag) frag {
+	n.link(f.end, g.start)
+	return frag{f.start, g.end}
+}
+
+func (n *nfa) alt(f, g frag) frag {
+	s, e := n.add(), n.add()
+	n.link(s, f.start, g.start)
+	n.link(f.end, e)
+	n.link(g.end, e)
+	return frag{s, e}
+}
+
+func (n *nfa) star(f frag) frag {
+	s, e := n.add(), n.add()
+	n.link(s, f.start, e)
+	n.link(f.end, f.start, e)
+	return frag{s, e}
+}
+
+func (n *nfa) plus(f frag) frag {
+	e := n.add()
+	n.link(f.end, f.start, e)
+	return frag{f.start, e}
+}
+
+func (n *nfa) quest(f frag) frag {
+	s := n.add()
+	n.link(s, f.start, f.end)
+	return frag{s, f.end}
+}
+
+// parser builds an NFA from an expression by recursive descent
+type parser struct {
+	expr []rune
+	pos  int
+	nfa  *nfa
+}
+
+// parse compiles expr into an NFA, returning the fragment that matches it
+func parse(expr string) (*nfa, frag, error) {
+	p := &parser{expr: []rune(expr), nfa: &nfa{}}
+	f, err := p.alternation()
+	if err != nil {
+		return nil, frag{}, err
+	}
+	if !p.eof() {
+		return nil, frag{}, p.errorf("unexpected ')'")
+	}
+	return p.nfa, f, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("regex: %s at position %d in '%s'", fmt.Sprintf(format, args...), p.pos, string(p.expr))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.expr)
+}
+
+func (p *parser) peek() rune {
+	return p.expr[p.pos]
+}
+
+func (p *parser) alternation() (frag, error) {
+	f, err := p.concat()
+	if err != nil {
+		return frag{}, err
+	}
+	for !p.eof() && p.peek() == '|' {
+		p.pos++
+		g, err := p.concat()
+		if err != nil {
+			return frag{}, err
+		}
+		f = p.nfa.alt(f, g)
+	}
+	return f, nil
+}
+
+func (p *parser) concat() (frag, error) {
+	f := p.nfa.empty()
+	for !p.eof() && p.peek() != '|' && p.peek() != ')' {
+		g, err := p.repeat()
+		if err != nil {
+			return frag{}, err
+		}
+		f = p.nfa.cat(f, g)
+	}
+	return f, nil
+}
+
+// repeat parses an atom followed by at most one repetition operator.  As in
+// RE2, an operator may be made non-greedy with a trailing '?', which matches
+// the same inputs, but cannot be followed by another operator.
+func (p *parser) repeat() (frag, error) {
+	f, err := p.atom()
+	if err != nil {
+		return frag{}, err
+	}
+	if p.eof() {
+		return f, nil
+	}
+	switch p.peek() {
+	case '*':
+		f = p.nfa.star(f)
+	case '+':
+		f = p.nfa.plus(f)
+	case '?':
+		f = p.nfa.quest(f)
+	default:
+		return f, nil
+	}
+
+	start := p.pos
+	p.pos++
+	if !p.eof() && p.peek() == '?' {
+		p.pos++
+	}
+	if !p.eof() && strings.ContainsRune("*+?", p.peek()) {
+		op := string(p.expr[start : p.pos+1])
+		p.pos = start
+		return frag{}, p.errorf("invalid nested repetition operator '%s'", op)
+	}
+	return f, nil
+}
+
+func (p *parser) atom() (frag, error) {
+	switch c := p.peek(); c {
+	case '(':
+		p.pos++
+		f, err := p.alternation()
+		if err != nil {
+			return frag{}, err
+		}
+		if p.eof() {
+			return frag{}, p.errorf("missing ')'")
+		}
+		p.pos++
+		return f, nil
+	case '*', '+', '?':
+		return frag{}, p.errorf("missing argument to repetition operator '%c'", c)
+	case '^', '$', '{', '}':
+		return frag{}, p.errorf("unsupported syntax '%c'", c)
+	case '.':
+		p.pos++
+		return p.nfa.set(dot), nil
+	case '[':
+		set, err := p.class()
+		if err != nil {
+			return frag{}, err
+		}
+		return p.nfa.set(set), nil
+	case '\\':
+		set, err := p.escape()
+		if err != nil {
+			return frag{}, err
+		}
+		return p.nfa.set(set), nil
+	default:
+		p.pos++
+		return p.nfa.set(single(c)), nil
+	}
+}
+
+// class parses a character class such as [a-z_] or [^0-9]
+func (p *parser) class() (runeSet, error) {
+	start := p.pos
+	p.pos++
+	negated := !p.eof() && p.peek() == '^'
+	if negated {
+		p.pos++
+	}
+
+	var ranges []runeRange
+	for first := true; ; first = false {
+		if p.eof() {
+			p.pos = start
+			return nil, p.errorf("missing ']'")
+		}
+		if p.peek() == ']' && !first {
+			p.pos++
+			break
+		}
+
+		lo, err := p.classRune()
+		if err != nil {
+			return nil, err
+		}
+		if len(lo) != 1 || lo[0].lo != lo[0].hi || p.pos+1 >= len(p.expr) || p.peek() != '-' || p.expr[p.pos+1] == ']' {
+			ranges = append(ranges, lo...)
+			continue
+		}
+
+		p.pos++
+		hi, err := p.classRune()
+		if err != nil {
+			return nil, err
+		}
+		if len(hi) != 1 || hi[0].lo != hi[0].hi || hi[0].lo < lo[0].lo {
+			return nil, p.errorf("invalid range")
+		}
+		ranges = append(ranges, runeRange{lo[0].lo, hi[0].lo})
+	}
+
+	set := normalize(ranges)
+	if negated {
+		set = set.negate()
+	}
+	return set, nil
+}
+
+// classRune parses a single rune or escape inside a character class
+func (p *parser) classRune() (runeSet, error) {
+	if p.peek() == '\\' {
+		return p.escape()
+	}
+	c := p.peek()
+	p.pos++
+	return single(c), nil
+}
+
+var perl = map[rune]runeSet{
+	'd': {{'0', '9'}},
+	'w': {{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}},
+	's': {{'\t', '\n'}, {'\f', '\r'}, {' ', ' '}},
+}
+
+var controls = map[rune]rune{
+	'a': '\a',
+	'f': '\f',
+	'n': '\n',
+	'r': '\r',
+	't': '\t',
+	'v': '\v',
+}
+
+// escape parses a backslash escape: a Perl class such as \d, a control
+// character such as \n, a hex rune such as \x41 or \x{263a}, or an escaped
+// punctuation character
+func (p *parser) escape() (runeSet, error) {
+	p.pos++
+	if p.eof() {
+		return nil, p.errorf("trailing backslash")
+	}
+	c := p.peek()
+	p.pos++
+
+	if set, ok := perl[c]; ok {
+		return set, nil
+	}
+	if set, ok := perl[c+'a'-'A']; ok && c >= 'A' && c <= 'Z' {
+		return set.negate(), nil
+	}
+	if r, ok := controls[c]; ok {
+		return single(r), nil
+	}
+	if c == 'x' {
+		return p.hex()
+	}
+	if c < 0x80 && !isAlnum(c) {
+		return single(c), nil
+	}
+	p.pos--
+	return nil, p.errorf("invalid escape '\\%c'", c)
+}
+
+// hex parses the digits of a \x escape: two digits, or any number in braces
+func (p *parser) hex() (runeSet, error) {
+	var digits string
+	if !p.eof() && p.peek() == '{' {
+		end := p.pos + 1
+		for end < len(p.expr) && p.expr[end] != '}' {
+			end++
+		}
+		if end == len(p.expr) {
+			return nil, p.errorf("missing '}'")
+		}
+		digits = string(p.expr[p.pos+1 : end])
+		p.pos = end + 1
+	} else {
+		if p.pos+2 > len(p.expr) {
+			return nil, p.errorf("invalid hex escape")
+		}
+		digits = string(p.expr[p.pos : p.pos+2])
+		p.pos += 2
+	}
+
+	n, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || n > 0x10ffff {
+		return nil, p.errorf("invalid hex escape")
+	}
+	return single(rune(n)), nil
+}
+
+func isAlnum(c rune) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
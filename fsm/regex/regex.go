@@ -0,0 +1,133 @@
+// Package regex compiles regular expressions into fsm machines over runes.
+//
+// The compiled automaton is deterministic: it has a state for every set of
+// positions the expression can be in, named q0 for the start state and q1,
+// q2 ... after it, and a transition for every set of runes that moves between
+// two of them.  Each transition is described by its rune set in regular
+// expression syntax.  An expression always matches the whole input.
+//
+// The supported syntax is a subset of RE2:
+//
+//	x         the rune x
+//	.         any rune but a newline
+//	[xyz]     a character class; ranges such as a-z and escapes are allowed
+//	[^xyz]    a negated character class
+//	\d \w \s  ASCII digits, word characters and whitespace
+//	\D \W \S  their negations
+//	\n \t ... the control characters \a \f \n \r \t \v
+//	\x7F      a rune in hex; \x{10FFFF} for any number of digits
+//	\*        a punctuation character, literally
+//	xy        x followed by y
+//	x|y       x or y
+//	x* x+ x?  zero or more, one or more, and zero or one x
+//	x*? x+?   the non-greedy forms of x* and x+, which match the same inputs
+//	x??       the non-greedy form of x?
+//	(x)       grouping
+//
+// Anchors, counted repetition and flags are not supported, and as in RE2,
+// repetition operators cannot be stacked, as in x**.
+package regex
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/schigh/state/fsm"
+)
+
+// Regexp is a compiled regular expression.  It is safe for concurrent use.
+type Regexp struct {
+	expr        string
+	states      []fsm.State
+	transitions []fsm.Transition
+	end         []string
+	def         *fsm.Definition
+}
+
+// Compile parses expr and builds the machine that accepts exactly the strings
+// it matches
+func Compile(expr string) (*Regexp, error) {
+	n, f, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	d := determinize(n, f)
+
+	re := &Regexp{expr: expr}
+	for i := range d.states {
+		re.states = append(re.states, fsm.NewState("q"+strconv.Itoa(i)))
+	}
+	for i, s := range d.states {
+		for _, e := range s.edges {
+			re.transitions = append(re.transitions, re.states[i].When(e.set.String(), accepts(e.set)).Then(re.states[e.to]))
+		}
+		if s.accept {
+			re.end = append(re.end, re.states[i].Name())
+		}
+	}
+
+	m := fsm.NewMachine(fsm.WithStates(re.states...), fsm.WithTransitions(re.transitions...))
+	if err := m.SetStart(re.states[0].Name()); err != nil {
+		return nil, err
+	}
+	if err := m.SetEndStates(re.end...); err != nil {
+		return nil, err
+	}
+	if re.def, err = m.Define(); err != nil {
+		return nil, err
+	}
+
+	return re, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed
+func MustCompile(expr string) *Regexp {
+	re, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// accepts is the trigger function of a transition on the runes in set
+func accepts(set runeSet) fsm.TriggerFunc {
+	return func(_ context.Context, v interface{}) (bool, error) {
+		r, ok := v.(rune)
+		return ok && set.contains(r), nil
+	}
+}
+
+// String returns the source text of the expression
+func (re *Regexp) String() string {
+	return re.expr
+}
+
+// States returns the states of the automaton, starting with the start state
+func (re *Regexp) States() []fsm.State {
+	return append([]fsm.State(nil), re.states...)
+}
+
+// Transitions returns the transitions of the automaton, grouped by the state
+// they leave in the order of States.  They can be passed to fsm.NewMachine
+// along with States to build a machine that can be extended further.
+func (re *Regexp) Transitions() []fsm.Transition {
+	return append([]fsm.Transition(nil), re.transitions...)
+}
+
+// EndStates returns the names of the accepting states
+func (re *Regexp) EndStates() []string {
+	return append([]string(nil), re.end...)
+}
+
+// Definition returns the definition of the automaton, with its start and end
+// states set.  Instances of it can be run over runes, and it can be analyzed
+// and graphed like any other definition.
+func (re *Regexp) Definition() *fsm.Definition {
+	return re.def
+}
+
+// MatchString reports whether the expression matches all of s
+func (re *Regexp) MatchString(s string) bool {
+	ok, _ := re.def.NewInstance().Accepts(context.Background(), fsm.Runes(s))
+	return ok
+}
@@ -0,0 +1,171 @@
+package regex
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/schigh/state/fsm"
+)
+
+func TestMatchString(t *testing.T) {
+	inputs := []string{
+		"", "a", "b", "c", "ab", "abc", "aabc", "aaabc", "abab", "ba", "abcabc",
+		"0", "42", "3.14", "3.", ".5", "x_1", "a b", "\t\n", "-", "]", "^",
+		"☺", "x☺y", "日本", "a.b", "a*b", "(a)", "\x00", "\xff",
+	}
+
+	for _, expr := range []string{
+		``,
+		`a`,
+		`a+bc`,
+		`a*b|c`,
+		`(ab|a)*b?`,
+		`(a|b)*abb`,
+		`a||b`,
+		`(|a)+`,
+		`((a*)*)*`,
+		`.`,
+		`.*`,
+		`..?`,
+		`[abc]+`,
+		`[^a-c]+`,
+		`[a-cx-z]*`,
+		`[]a]`,
+		`[a-]`,
+		`[^^]`,
+		`[\d.]+`,
+		`[\]\\]`,
+		`\d+(\.\d*)?`,
+		`\w+`,
+		`\W`,
+		`\s*\S`,
+		`\D\d`,
+		`a\.b`,
+		`a\*b`,
+		`\(a\)`,
+		`\x41|\x{263a}`,
+		`[\x{3000}-\x{9fff}]+`,
+		`x.y`,
+		`\t\n`,
+	} {
+		want := regexp.MustCompile(`^(?:` + expr + `)$`)
+		re, err := Compile(expr)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if re.String() != expr {
+			t.Fatalf("expected %s, got %s", expr, re.String())
+		}
+
+		for _, in := range inputs {
+			if got := re.MatchString(in); got != want.MatchString(in) {
+				t.Errorf("%s: %q: expected %v", expr, in, !got)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for expr, want := range map[string]string{
+		`(a`:     "missing ')' at position 2",
+		`a)`:     "unexpected ')' at position 1",
+		`*a`:     "missing argument to repetition operator '*' at position 0",
+		`a|+`:    "missing argument to repetition operator '+' at position 2",
+		`(?a)`:   "missing argument to repetition operator '?' at position 1",
+		`[ab`:    "missing ']' at position 0",
+		`[z-a]`:  "invalid range at position 4",
+		`[\d-z]`: "",
+		`a\`:     "trailing backslash at position 2",
+		`\q`:     "invalid escape '\\q' at position 1",
+		`\x4`:    "invalid hex escape at position 2",
+		`\x{12`:  "missing '}' at position 2",
+		`\x{}`:   "invalid hex escape at position 4",
+		`^a`:     "unsupported syntax '^' at position 0",
+		`a{2}`:   "unsupported syntax '{' at position 1",
+		`a**`:    "invalid nested repetition operator '**' at position 1",
+		`a+?*`:   "invalid nested repetition operator '+?*' at position 1",
+		`(a?)+?`: "",
+	} {
+		_, err := Compile(expr)
+		if want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", expr, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), "regex: "+want) {
+			t.Errorf("%s: expected %q, got %v", expr, want, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	MustCompile(`(`)
+}
+
+func TestAutomaton(t *testing.T) {
+	re := MustCompile(`a+bc`)
+
+	var desc []string
+	for _, tr := range re.Transitions() {
+		desc = append(desc, tr.From().Name()+" -"+tr.Description()+"-> "+tr.To().Name())
+	}
+	if got, want := strings.Join(desc, ", "), "q0 -a-> q1, q1 -a-> q1, q1 -b-> q2, q2 -c-> q3"; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if got := re.EndStates(); len(got) != 1 || got[0] != "q3" {
+		t.Fatalf("unexpected end states: %v", got)
+	}
+	if states := re.States(); len(states) != 4 || states[0].Name() != "q0" {
+		t.Fatalf("unexpected states: %v", states)
+	}
+
+	if r := re.Definition().Analyze(); len(r.Unreachable) != 0 || len(r.DeadEnds) != 0 {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+
+	var buf bytes.Buffer
+	if err := re.Definition().Mermaid(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "s1 --> s1 : a") {
+		t.Fatalf("unexpected graph:\n%s", buf.String())
+	}
+}
+
+func TestExtend(t *testing.T) {
+	ctx := context.Background()
+	re := MustCompile(`a+bc`)
+	states := re.States()
+
+	// accept any number of a+bc separated by commas
+	comma := states[3].When(",", func(_ context.Context, v interface{}) (bool, error) {
+		return v == ',', nil
+	}).Then(states[0])
+	m := fsm.NewMachine(fsm.WithStates(states...), fsm.WithTransitions(append(re.Transitions(), comma)...))
+	if err := m.SetEndStates(re.EndStates()...); err != nil {
+		t.Fatal(err)
+	}
+
+	for word, want := range map[string]bool{
+		"abc":         true,
+		"abc,aabc":    true,
+		"abc,":        false,
+		"abc,abc,abc": true,
+		"abc,,abc":    false,
+	} {
+		got, err := m.Accepts(ctx, fsm.Runes(word))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%q: expected %v", word, want)
+		}
+	}
+}
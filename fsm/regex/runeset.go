@@ -0,0 +1,123 @@
+package regex
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// runeRange is an inclusive range of runes
+type runeRange struct {
+	lo, hi rune
+}
+
+// runeSet is a set of runes made of sorted ranges that neither overlap nor
+// touch
+type runeSet []runeRange
+
+var (
+	anyRune = runeSet{{0, unicode.MaxRune}}
+	// dot is matched by '.': any rune but a newline, as in RE2
+	dot = runeSet{{0, '\n' - 1}, {'\n' + 1, unicode.MaxRune}}
+)
+
+func single(r rune) runeSet {
+	return runeSet{{r, r}}
+}
+
+// normalize sorts ranges and merges the ones that overlap or touch
+func normalize(ranges []runeRange) runeSet {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := append([]runeRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].lo < sorted[j].lo
+	})
+
+	out := runeSet{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &out[len(out)-1]
+		if r.lo <= last.hi+1 {
+			if r.hi > last.hi {
+				last.hi = r.hi
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (s runeSet) contains(r rune) bool {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].hi >= r
+	})
+	return i < len(s) && s[i].lo <= r
+}
+
+// negate returns every rune not in s
+func (s runeSet) negate() runeSet {
+	var out runeSet
+	next := rune(0)
+	for _, r := range s {
+		if r.lo > next {
+			out = append(out, runeRange{next, r.lo - 1})
+		}
+		next = r.hi + 1
+	}
+	if next <= unicode.MaxRune {
+		out = append(out, runeRange{next, unicode.MaxRune})
+	}
+	return out
+}
+
+// String renders s in regular expression syntax: a single rune, '.', or a
+// character class, negated when that is shorter
+func (s runeSet) String() string {
+	switch {
+	case len(s) == 2 && s[0] == dot[0] && s[1] == dot[1]:
+		return "."
+	case len(s) == 1 && s[0].lo == s[0].hi:
+		return quote(s[0].lo, "^$.|?*+()[]{}\\")
+	}
+
+	set, prefix := s, "["
+	if neg := s.negate(); len(neg) > 0 && len(neg) < len(s) {
+		set, prefix = neg, "[^"
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, r := range set {
+		b.WriteString(quote(r.lo, "^-[]\\"))
+		if r.hi > r.lo {
+			if r.hi > r.lo+1 {
+				b.WriteByte('-')
+			}
+			b.WriteString(quote(r.hi, "^-[]\\"))
+		}
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// quote renders r so that the parser reads it back as r, escaping the runes
+// in special and any that are not printable
+func quote(r rune, special string) string {
+	for c, control := range controls {
+		if r == control {
+			return `\` + string(c)
+		}
+	}
+
+	switch {
+	case strings.ContainsRune(special, r):
+		return `\` + string(r)
+	case unicode.IsPrint(r):
+		return string(r)
+	default:
+		return `\x{` + strconv.FormatInt(int64(r), 16) + `}`
+	}
+}
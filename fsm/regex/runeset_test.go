@@ -0,0 +1,57 @@
+package regex
+
+import (
+	"testing"
+	"unicode"
+)
+
+func TestRuneSet(t *testing.T) {
+	set := normalize([]runeRange{{'x', 'z'}, {'a', 'c'}, {'b', 'f'}, {'g', 'g'}})
+	if len(set) != 2 || set[0] != (runeRange{'a', 'g'}) || set[1] != (runeRange{'x', 'z'}) {
+		t.Fatalf("unexpected set: %v", []runeRange(set))
+	}
+	for r, want := range map[rune]bool{'a': true, 'g': true, 'h': false, 'x': true, 'z': true, '{': false, 0: false} {
+		if set.contains(r) != want {
+			t.Fatalf("%q: expected %v", r, want)
+		}
+	}
+
+	neg := set.negate()
+	if len(neg) != 3 || neg[0].lo != 0 || neg[2].hi != unicode.MaxRune {
+		t.Fatalf("unexpected negation: %v", []runeRange(neg))
+	}
+	if back := neg.negate(); len(back) != 2 || back[0] != set[0] || back[1] != set[1] {
+		t.Fatalf("unexpected double negation: %v", []runeRange(back))
+	}
+}
+
+func TestRuneSetString(t *testing.T) {
+	for want, set := range map[string]runeSet{
+		`.`:                  dot,
+		`[\x{0}-\x{10ffff}]`: anyRune,
+		`\n`:                 single('\n'),
+		`a`:                  single('a'),
+		`\.`:                 single('.'),
+		`\x{0}`:              single(0),
+		`[0-9]`:              {{'0', '9'}},
+		`[ab]`:               {{'a', 'b'}},
+		`[a-cx]`:             {{'a', 'c'}, {'x', 'x'}},
+		`[\-\]\^]`:           {{'-', '-'}, {']', '^'}},
+		`[^a]`:               single('a').negate(),
+		`[^0-9A-Z]`:          normalize([]runeRange{{'0', '9'}, {'A', 'Z'}}).negate(),
+		`[\x{0}-\t]`:         {{0, '\t'}},
+	} {
+		if got := set.String(); got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+
+		// the rendering parses back to the same set
+		re, err := Compile(want)
+		if err != nil {
+			t.Fatalf("%s: %v", want, err)
+		}
+		if got := re.Transitions()[0].Description(); got != want {
+			t.Errorf("expected %s to round trip, got %s", want, got)
+		}
+	}
+}
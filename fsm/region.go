@@ -0,0 +1,107 @@
+package fsm
+
+import (
+	"fmt"
+)
+
+// region is an orthogonal part of a machine that is active at the same time
+// as the main region.  A region is made up of the states reachable from its
+// start state.
+type region struct {
+	name  string
+	start State
+}
+
+// WithRegion adds an orthogonal region to the machine, starting in start.
+// Every region is active at once: Update dispatches each value to all of
+// them, and the machine is only in an end state when every region is.
+func WithRegion(name string, start State) Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.addRegion(name, start)
+	}
+}
+
+// AddRegion adds an orthogonal region to the machine.  See WithRegion.
+func (m *machine) AddRegion(name string, start State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addRegion(name, start)
+}
+
+// CurrentStates returns the current leaf state of every region, starting
+// with the main region.  For an NFA machine, it returns every state the
+// machine is in, in order of name.
+func (m *machine) CurrentStates() []State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.currentStates()
+}
+
+func (m *machine) addRegion(name string, start State) {
+	if name == "" || start == nil {
+		panic("region must have a name and a start state")
+	}
+	for _, r := range m.regions {
+		if r.name == name {
+			panic(fmt.Sprintf("duplicate region: '%s'", name))
+		}
+	}
+
+	m.regions = append(m.regions, region{name: name, start: start})
+}
+
+// roots returns the start state of the main region followed by the start
+// states of every other region.  The caller must hold m.mu.
+func (m *machine) roots() []State {
+	var out []State
+	if m.start != nil {
+		out = append(out, m.start)
+	}
+	for _, r := range m.regions {
+		out = append(out, r.start)
+	}
+	return out
+}
+
+// active returns the current leaf state of every region, starting with the
+// main region.  It returns nil if the main region has no start state.  The
+// caller must hold m.mu.
+func (m *machine) active() []State {
+	curr := m.current()
+	if curr == nil {
+		return nil
+	}
+
+	out := []State{curr}
+	for i, r := range m.regions {
+		if i >= len(m.parallel) || m.parallel[i] == nil {
+			out = append(out, m.descend(r.start))
+			continue
+		}
+		out = append(out, m.parallel[i])
+	}
+	return out
+}
+
+// setActive stores the current leaf state of every region, as returned by
+// active.  The caller must hold m.mu.
+func (m *machine) setActive(states []State) {
+	m.setCurrent(states[0])
+	m.parallel = append(m.parallel[:0], states[1:]...)
+}
+
+// isFinal reports whether s, or any state enclosing it, is an end state.
+// The caller must hold m.mu.
+func (m *machine) isFinal(s State) bool {
+	for _, a := range m.ancestors(s) {
+		if _, ok := m.endStates[a.Id()]; ok {
+			return true
+		}
+	}
+	return false
+}
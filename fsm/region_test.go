@@ -0,0 +1,142 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// orderStatus tracks payment and fulfilment of an order in parallel
+func orderStatus(t *testing.T) *machine {
+	t.Helper()
+
+	var (
+		unpaid    = NewState("unpaid")
+		paid      = NewState("paid")
+		pending   = NewState("pending")
+		shipped   = NewState("shipped")
+		delivered = NewState("delivered")
+	)
+
+	m := NewMachine(
+		WithTransitions(
+			unpaid.When("pay", strIs("pay")).Then(paid),
+			pending.When("ship", strIs("ship")).Then(shipped),
+			shipped.When("deliver", strIs("deliver")).Then(delivered),
+		),
+		WithRegion("fulfilment", pending),
+	)
+	if err := m.SetEndStates("paid", "delivered"); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestRegions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("dispatch to every region", func(t *testing.T) {
+		m := orderStatus(t)
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(m.CurrentStates()); !reflect.DeepEqual(got, []string{"unpaid", "pending"}) {
+			t.Fatalf("unexpected states: %v", got)
+		}
+
+		for _, tc := range []struct {
+			input string
+			want  []string
+			end   bool
+		}{
+			{"ship", []string{"unpaid", "shipped"}, false},
+			{"pay", []string{"paid", "shipped"}, false},
+			{"deliver", []string{"paid", "delivered"}, true},
+		} {
+			changed, err := m.Update(ctx, tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Fatalf("%s: change expected", tc.input)
+			}
+			if got := names(m.CurrentStates()); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("%s: expected %v, got %v", tc.input, tc.want, got)
+			}
+			if m.IsEndState() != tc.end {
+				t.Fatalf("%s: expected end state %v", tc.input, tc.end)
+			}
+		}
+
+		if changed, err := m.Update(ctx, "nope"); changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+
+		if err := m.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(m.CurrentStates()); !reflect.DeepEqual(got, []string{"unpaid", "pending"}) {
+			t.Fatalf("unexpected states after reset: %v", got)
+		}
+	})
+
+	t.Run("errors leave every region unchanged", func(t *testing.T) {
+		errAction := errors.New("action failed")
+		m := orderStatus(t)
+
+		a := NewState("a")
+		b := NewState("b", OnEnter(func(context.Context, interface{}) error {
+			return errAction
+		}))
+		m.AddTransition(a.When("deliver", strIs("deliver")).Then(b))
+		m.AddRegion("extra", a)
+
+		for _, input := range []string{"pay", "ship"} {
+			if _, err := m.Update(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// "deliver" moves the fulfilment region, then fails entering b
+		changed, err := m.Update(ctx, "deliver")
+		if !errors.Is(err, errAction) || changed {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+		if got := names(m.CurrentStates()); !reflect.DeepEqual(got, []string{"paid", "shipped", "a"}) {
+			t.Fatalf("unexpected states: %v", got)
+		}
+	})
+
+	t.Run("shared states are invalid", func(t *testing.T) {
+		m := orderStatus(t)
+		m.AddTransition(m.lookup("shipped").When("refund", strIs("refund")).Then(m.lookup("unpaid")))
+
+		if got := names(m.Analyze().Conflicts); !reflect.DeepEqual(got, []string{"unpaid", "paid"}) {
+			t.Fatalf("unexpected conflicts: %v", got)
+		}
+		if err := m.Validate(); err == nil {
+			t.Fatal("expected invalid machine")
+		}
+	})
+
+	t.Run("invalid regions panic", func(t *testing.T) {
+		a := NewState("a")
+		for name, f := range map[string]func(){
+			"no name":   func() { NewMachine(WithRegion("", a)) },
+			"no start":  func() { NewMachine(WithRegion("r", nil)) },
+			"duplicate": func() { NewMachine(WithRegion("r", a), WithRegion("r", a)) },
+		} {
+			f := f
+			t.Run(name, func(t *testing.T) {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Fatal("panic expected")
+					}
+				}()
+				f()
+			})
+		}
+	})
+}
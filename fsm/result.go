@@ -0,0 +1,124 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+)
+
+// Result describes what a call to Step did
+type Result struct {
+	// Transition is the transition taken, or nil if the value was not
+	// accepted
+	Transition Transition
+	// From is the leaf state the machine was in
+	From State
+	// To is the leaf state the machine is in now
+	To State
+	// HasTransitions reports whether From has any transitions driven by
+	// values, directly or through its enclosing states.  A state without any
+	// cannot accept a value.
+	HasTransitions bool
+	// Regions holds the results of the other regions of the machine, in the
+	// order they were added
+	Regions []Result
+}
+
+// Changed reports whether any region took a transition
+func (r Result) Changed() bool {
+	if r.Transition != nil {
+		return true
+	}
+	for _, rr := range r.Regions {
+		if rr.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Rejected reports whether the value was not accepted even though the
+// machine has transitions that could have accepted it: no region took a
+// transition, and at least one of them has transitions driven by values.
+func (r Result) Rejected() bool {
+	if r.Changed() {
+		return false
+	}
+	if r.HasTransitions {
+		return true
+	}
+	for _, rr := range r.Regions {
+		if rr.HasTransitions {
+			return true
+		}
+	}
+	return false
+}
+
+// Step evaluates the transitions out of the current states against value,
+// as Update does, and reports which transitions were taken and whether the
+// value was rejected or arrived in a state that takes no values.  On error,
+// the result describes the unchanged machine.  For an NFA machine, it
+// describes the first of its states and the first transition taken, and
+// HasTransitions reports whether any of its states has transitions.
+func (m *machine) Step(ctx context.Context, value interface{}) (Result, error) {
+	defer m.publish()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	default:
+	}
+
+	var (
+		r   Result
+		err error
+	)
+	switch {
+	case m.nondeterministic:
+		r, err = m.stepNFA(ctx, value)
+	case m.flat():
+		r, err = m.stepFlat(ctx, value)
+	default:
+		r, err = m.step(ctx, value, func(ctx context.Context, curr State) (Transition, error) {
+			return m.match(ctx, curr, value)
+		})
+	}
+	if err == nil && !r.Changed() && m.noTransitionError {
+		return r, fmt.Errorf("%w: state '%s' does not accept %v", ErrNoTransition, r.From.Name(), value)
+	}
+
+	return r, err
+}
+
+// result describes the move of every region from the states in from to the
+// states in to, along the transitions in taken.  The caller must hold m.mu.
+func (m *machine) result(from []State, taken []Transition, to []State) Result {
+	var r Result
+	for i := range from {
+		rr := Result{From: from[i], To: to[i], HasTransitions: m.hasTransitions(from[i])}
+		if i < len(taken) {
+			rr.Transition = taken[i]
+		}
+		if i == 0 {
+			r = rr
+			continue
+		}
+		r.Regions = append(r.Regions, rr)
+	}
+	return r
+}
+
+// hasTransitions reports whether s or any state enclosing it has transitions
+// driven by values.  The caller must hold m.mu.
+func (m *machine) hasTransitions(s State) bool {
+	for ; s != nil; s = m.parents[s.Id()] {
+		for _, t := range m.transitions[s.Id()] {
+			if timeoutOf(t) == 0 && !isEpsilon(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
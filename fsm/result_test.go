@@ -0,0 +1,101 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStep(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("accepted, rejected and terminal", func(t *testing.T) {
+		m := recognizer(t)
+
+		r, err := m.Step(ctx, byte('a'))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Changed() || r.Rejected() || r.Transition.Description() != "a" || r.From.Name() != "start" || r.To.Name() != "a" {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+
+		r, err = m.Step(ctx, byte('c'))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Changed() || !r.Rejected() || r.Transition != nil || r.From.Name() != "a" || r.To.Name() != "a" {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+
+		for _, b := range []byte("bc") {
+			if _, err := m.Step(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r, err = m.Step(ctx, byte('c'))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Changed() || r.Rejected() || r.HasTransitions {
+			t.Fatalf("expected terminal state, got %+v", r)
+		}
+	})
+
+	t.Run("timed transitions do not take values", func(t *testing.T) {
+		a := NewState("a")
+		m := NewMachine(WithTransitions(After(a, time.Second).Then(NewState("b"))))
+		r, err := m.Step(ctx, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.HasTransitions || r.Rejected() {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+	})
+
+	t.Run("regions", func(t *testing.T) {
+		m := orderStatus(t)
+		r, err := m.Step(ctx, "ship")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Changed() || r.Transition != nil || len(r.Regions) != 1 {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+		got := []string{r.Regions[0].From.Name(), r.Regions[0].Transition.Description(), r.Regions[0].To.Name()}
+		if !reflect.DeepEqual(got, []string{"pending", "ship", "shipped"}) {
+			t.Fatalf("unexpected region result: %v", got)
+		}
+
+		for _, input := range []string{"pay", "deliver"} {
+			if _, err := m.Step(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if r, _ := m.Step(ctx, "pay"); r.Rejected() {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		errGuard := errors.New("guard error")
+		a := NewState("a")
+		m := NewMachine(WithTransitions(a.When("x", func(context.Context, interface{}) (bool, error) {
+			return false, errGuard
+		}).Then(NewState("b"))))
+		r, err := m.Step(ctx, "x")
+		if !errors.Is(err, errGuard) || r.Changed() || r.From.Name() != "a" || r.To.Name() != "a" {
+			t.Fatalf("unexpected result %+v with %v", r, err)
+		}
+
+		m = recognizer(t)
+		WithNoTransitionError()(m)
+		r, err = m.Step(ctx, byte('z'))
+		if !errors.Is(err, ErrNoTransition) || !r.Rejected() {
+			t.Fatalf("unexpected result %+v with %v", r, err)
+		}
+	})
+}
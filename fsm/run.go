@@ -0,0 +1,121 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Seq is a sequence of input values.  It calls yield with each value in turn
+// and stops early if yield returns false.  With Go 1.23 or later, an
+// iter.Seq[any] has the same underlying type and can be converted to a Seq,
+// as in Seq(it).
+type Seq func(yield func(interface{}) bool)
+
+// Slice returns a Seq over the elements of values
+func Slice[T any](values []T) Seq {
+	return func(yield func(interface{}) bool) {
+		for _, v := range values {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Chan returns a Seq over the values received from ch until it is closed
+func Chan[T any](ch <-chan T) Seq {
+	return func(yield func(interface{}) bool) {
+		for v := range ch {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Bytes returns a Seq over the bytes of s
+func Bytes(s string) Seq {
+	return func(yield func(interface{}) bool) {
+		for i := 0; i < len(s); i++ {
+			if !yield(s[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Runes returns a Seq over the runes of s
+func Runes(s string) Seq {
+	return func(yield func(interface{}) bool) {
+		for _, r := range s {
+			if !yield(r) {
+				return
+			}
+		}
+	}
+}
+
+// RejectedError is returned by Run when no transition accepts an input.  It
+// matches ErrNoTransition.
+type RejectedError struct {
+	// Position is the index of the rejected input in the sequence
+	Position int
+	// Value is the rejected input
+	Value interface{}
+	// State is the leaf state that rejected it
+	State State
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("input %d (%v) rejected in state '%s'", e.Position, e.Value, e.State.Name())
+}
+
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrNoTransition
+}
+
+// Run feeds inputs to the machine one at a time, starting from its current
+// state, and returns the number of inputs it accepted.  It stops at the first
+// input that no transition accepts and returns a RejectedError for it, or at
+// the first error returned by Step.  Stopping early does not consume the
+// rest of the sequence.
+func (m *machine) Run(ctx context.Context, inputs Seq) (int, error) {
+	var (
+		n   int
+		err error
+	)
+	inputs(func(v interface{}) bool {
+		var r Result
+		r, err = m.Step(ctx, v)
+		if errors.Is(err, ErrNoTransition) || (err == nil && !r.Changed()) {
+			err = &RejectedError{Position: n, Value: v, State: r.From}
+		}
+		if err != nil {
+			return false
+		}
+		n++
+		return true
+	})
+
+	return n, err
+}
+
+// Accepts resets the machine, runs it over inputs and reports whether every
+// input was accepted and the machine finished in an end state.  A rejected
+// input is not an error; errors from Reset or Step are returned.
+func (m *machine) Accepts(ctx context.Context, inputs Seq) (bool, error) {
+	if err := m.Reset(); err != nil {
+		return false, err
+	}
+
+	_, err := m.Run(ctx, inputs)
+	if errors.Is(err, ErrNoTransition) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return m.IsEndState(), nil
+}
@@ -0,0 +1,108 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAccepts(t *testing.T) {
+	ctx := context.Background()
+	m := recognizer(t)
+
+	for word, want := range map[string]bool{
+		"abc":      true,
+		"cba":      false,
+		"aaaaaabc": true,
+		"aaababc":  false,
+		"ab":       false,
+		"abcc":     false,
+		"":         false,
+	} {
+		got, err := m.Accepts(ctx, Bytes(word))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%q: expected %v", word, want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("rejection", func(t *testing.T) {
+		m := recognizer(t)
+		n, err := m.Run(ctx, Bytes("aabac"))
+		var re *RejectedError
+		if !errors.As(err, &re) || !errors.Is(err, ErrNoTransition) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 3 || re.Position != 3 || re.Value != byte('a') || re.State.Name() != "b" {
+			t.Fatalf("unexpected rejection after %d inputs: %+v", n, re)
+		}
+
+		// runs continue from the current state
+		if n, err := m.Run(ctx, Bytes("c")); n != 1 || err != nil {
+			t.Fatalf("n=%d err=%v", n, err)
+		}
+		if !m.IsEndState() {
+			t.Fatal("expected end state")
+		}
+	})
+
+	t.Run("stops consuming", func(t *testing.T) {
+		ch := make(chan byte, 4)
+		for _, b := range []byte("axbc") {
+			ch <- b
+		}
+		close(ch)
+
+		m := recognizer(t)
+		if n, err := m.Run(ctx, Chan(ch)); n != 1 || !errors.Is(err, ErrNoTransition) {
+			t.Fatalf("n=%d err=%v", n, err)
+		}
+		if b := <-ch; b != 'b' {
+			t.Fatalf("expected b to be left, got %c", b)
+		}
+	})
+
+	t.Run("slices", func(t *testing.T) {
+		m := orderStatus(t)
+		if n, err := m.Run(ctx, Slice([]string{"pay", "ship", "deliver"})); n != 3 || err != nil {
+			t.Fatalf("n=%d err=%v", n, err)
+		}
+		if !m.IsEndState() {
+			t.Fatal("expected end state")
+		}
+	})
+
+	t.Run("runes", func(t *testing.T) {
+		var got []rune
+		Runes("añb")(func(v interface{}) bool {
+			got = append(got, v.(rune))
+			return true
+		})
+		if string(got) != "añb" {
+			t.Fatalf("unexpected runes: %q", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		errGuard := errors.New("guard error")
+		a := NewState("a")
+		m := NewMachine(WithTransitions(a.When("x", func(context.Context, interface{}) (bool, error) {
+			return false, errGuard
+		}).Then(a)))
+		if n, err := m.Run(ctx, Bytes("x")); n != 0 || !errors.Is(err, errGuard) {
+			t.Fatalf("n=%d err=%v", n, err)
+		}
+		if ok, err := m.Accepts(ctx, Bytes("x")); ok || !errors.Is(err, errGuard) {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+		if ok, err := NewMachine().Accepts(ctx, Bytes("x")); ok || !errors.Is(err, ErrNoStartState) {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+	})
+}
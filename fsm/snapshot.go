@@ -0,0 +1,207 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SnapshotVersion is the version of the Snapshot format produced by this
+// package.  Restore rejects snapshots with any other version.
+const SnapshotVersion = 1
+
+// Snapshot is a serializable record of where a machine is.  States are
+// recorded by name, so a snapshot can be restored into a machine built from
+// the same definition in another process.
+type Snapshot struct {
+	// Version is the snapshot format version
+	Version int `json:"version" yaml:"version"`
+	// Current holds the current leaf state of every region, main region first,
+	// or every state an NFA machine is in
+	Current []string `json:"current" yaml:"current"`
+	// History maps each composite state that has been exited to the leaf
+	// state that was active when it was
+	History map[string]string `json:"history,omitempty" yaml:"history,omitempty"`
+	// Entered holds the time each active state was entered, for timed
+	// transitions
+	Entered map[string]time.Time `json:"entered,omitempty" yaml:"entered,omitempty"`
+	// Data is the extended state.  Values must be serializable along with
+	// the snapshot, and come back as the types the decoder chooses, such as
+	// float64 for JSON numbers, except for the extended state of a typed
+	// machine, which Restore converts back to its type.
+	Data Data `json:"data,omitempty" yaml:"data,omitempty"`
+}
+
+// Snapshot returns a record of the machine's current states, history, timers
+// and extended state that can be persisted and later passed to Restore
+func (m *machine) Snapshot() Snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snap := Snapshot{Version: SnapshotVersion, Data: m.extendedState().Clone()}
+	for _, s := range m.currentStates() {
+		snap.Current = append(snap.Current, s.Name())
+	}
+	for _, s := range m.states() {
+		if leaf, ok := m.history[s.Id()]; ok {
+			if snap.History == nil {
+				snap.History = make(map[string]string)
+			}
+			snap.History[s.Name()] = leaf.Name()
+		}
+		if at, ok := m.entered[s.Id()]; ok {
+			if snap.Entered == nil {
+				snap.Entered = make(map[string]time.Time)
+			}
+			snap.Entered[s.Name()] = at
+		}
+	}
+
+	return snap
+}
+
+// Restore puts the machine back where it was when snap was taken.  The
+// snapshot is checked against the machine's definition first: every state
+// it names must exist and be valid where it is used, or Restore returns an
+// error and leaves the machine unchanged.  No actions are run.  The extended
+// state of a typed machine is converted back to its type, through
+// encoding/json if the snapshot was decoded.
+func (m *machine) Restore(snap Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", snap.Version)
+	}
+	if m.current() == nil {
+		return ErrNoStartState
+	}
+	next, set, err := m.restoreCurrent(snap.Current)
+	if err != nil {
+		return err
+	}
+
+	var history map[uint64]State
+	for name, leafName := range snap.History {
+		parent := m.lookup(name)
+		if parent == nil {
+			return fmt.Errorf("%w: '%s'", ErrUnknownState, name)
+		}
+		leaf, err := m.restoreLeaf(leafName)
+		if err != nil {
+			return err
+		}
+		if !m.encloses(parent, leaf) {
+			return fmt.Errorf("state '%s' is not a substate of '%s'", leafName, name)
+		}
+		if history == nil {
+			history = make(map[uint64]State)
+		}
+		history[parent.Id()] = leaf
+	}
+
+	data := snap.Data.Clone()
+	if data == nil {
+		data = Data{}
+	}
+	if v, ok := data[typedKey]; ok && m.typed != nil {
+		c, err := m.typed(v)
+		if err != nil {
+			return fmt.Errorf("cannot restore typed extended state: %w", err)
+		}
+		data[typedKey] = c
+	}
+
+	var entered map[uint64]time.Time
+	for name, at := range snap.Entered {
+		s := m.lookup(name)
+		if s == nil {
+			return fmt.Errorf("%w: '%s'", ErrUnknownState, name)
+		}
+		if entered == nil {
+			entered = make(map[uint64]time.Time)
+		}
+		entered[s.Id()] = at
+	}
+
+	m.setActive(next)
+	m.set = set
+	m.data = data
+	m.history = history
+	m.entered = entered
+	m.notify()
+
+	return nil
+}
+
+// restoreCurrent finds the current states recorded in a snapshot: the leaf
+// state of every region, or the states of an NFA machine, which are also
+// returned as a set.  The caller must hold m.mu.
+func (m *machine) restoreCurrent(names []string) ([]State, []State, error) {
+	roots := m.roots()
+	if m.nondeterministic {
+		if len(names) == 0 {
+			return nil, nil, errors.New("snapshot has no current states")
+		}
+		roots = make([]State, len(names))
+		for i := range names {
+			roots[i] = m.start
+		}
+	}
+	if len(names) != len(roots) {
+		return nil, nil, fmt.Errorf("snapshot has %d regions, machine has %d", len(names), len(roots))
+	}
+
+	next := make([]State, len(roots))
+	for i, name := range names {
+		s, err := m.restoreLeaf(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !m.reachable(roots[i])[s.Id()] {
+			return nil, nil, fmt.Errorf("state '%s' cannot be reached from '%s'", name, roots[i].Name())
+		}
+		next[i] = s
+	}
+	if !m.nondeterministic {
+		return next, nil, nil
+	}
+
+	seen := make(map[uint64]bool)
+	var set []State
+	for _, s := range next {
+		if !seen[s.Id()] {
+			seen[s.Id()] = true
+			set = append(set, s)
+		}
+	}
+	sort.SliceStable(set, func(i, j int) bool {
+		return set[i].Name() < set[j].Name()
+	})
+	return set[:1], set, nil
+}
+
+// restoreLeaf finds the leaf state with the given name.  The caller must hold
+// m.mu.
+func (m *machine) restoreLeaf(name string) (State, error) {
+	s := m.lookup(name)
+	if s == nil {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownState, name)
+	}
+	if len(m.children[s.Id()]) > 0 {
+		return nil, fmt.Errorf("state '%s' is not a leaf state", name)
+	}
+	return s, nil
+}
+
+// encloses reports whether s is a proper descendant of parent.  The caller
+// must hold m.mu.
+func (m *machine) encloses(parent, s State) bool {
+	for _, a := range m.ancestors(s)[1:] {
+		if a.Id() == parent.Id() {
+			return true
+		}
+	}
+	return false
+}
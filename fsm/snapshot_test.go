@@ -0,0 +1,136 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("round trip", func(t *testing.T) {
+		m := pausable(t)
+		for _, input := range []string{"picked", "wrapped", "pause"} {
+			if _, err := m.Update(ctx, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		b, err := json.Marshal(m.Snapshot())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := snap.Entered["Paused"]; !ok || len(snap.Entered) != 1 {
+			t.Fatalf("unexpected entered times: %v", snap.Entered)
+		}
+		snap.Entered = nil
+		want := Snapshot{
+			Version: SnapshotVersion,
+			Current: []string{"Paused"},
+			History: map[string]string{"Active": "Boxing", "Packing": "Boxing"},
+		}
+		if !reflect.DeepEqual(snap, want) {
+			t.Fatalf("unexpected snapshot: %+v", snap)
+		}
+
+		// a machine built from the same definition carries on where m was
+		restored := pausable(t)
+		if err := restored.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := restored.Update(ctx, "resume deep"); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(restored.CurrentPath()); !reflect.DeepEqual(got, []string{"Active", "Packing", "Boxing"}) {
+			t.Fatalf("unexpected path: %v", got)
+		}
+	})
+
+	t.Run("regions and timers", func(t *testing.T) {
+		m := orderStatus(t)
+		if _, err := m.Update(ctx, "ship"); err != nil {
+			t.Fatal(err)
+		}
+		restored := orderStatus(t)
+		if err := restored.Restore(m.Snapshot()); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(restored.CurrentStates()); !reflect.DeepEqual(got, []string{"unpaid", "shipped"}) {
+			t.Fatalf("unexpected states: %v", got)
+		}
+
+		clock := newManualClock()
+		p := payment(t, clock)
+		if _, err := p.Tick(ctx); err != nil {
+			t.Fatal(err)
+		}
+		snap := p.Snapshot()
+		if !snap.Entered["AwaitingPayment"].Equal(clock.Now()) {
+			t.Fatalf("unexpected entered times: %v", snap.Entered)
+		}
+
+		clock.Advance(15 * time.Minute)
+		restoredPayment := payment(t, clock)
+		if err := restoredPayment.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+		if changed, _ := restoredPayment.Tick(ctx); !changed {
+			t.Fatal("change expected")
+		}
+	})
+
+	t.Run("invalid snapshots", func(t *testing.T) {
+		tests := []struct {
+			name string
+			snap Snapshot
+		}{
+			{"version", Snapshot{Version: 0, Current: []string{"Paused"}}},
+			{"regions", Snapshot{Version: SnapshotVersion, Current: []string{"Paused", "Paused"}}},
+			{"unknown state", Snapshot{Version: SnapshotVersion, Current: []string{"Gone"}}},
+			{"composite state", Snapshot{Version: SnapshotVersion, Current: []string{"Packing"}}},
+			{"unknown history", Snapshot{
+				Version: SnapshotVersion,
+				Current: []string{"Paused"},
+				History: map[string]string{"Gone": "Boxing"},
+			}},
+			{"history outside parent", Snapshot{
+				Version: SnapshotVersion,
+				Current: []string{"Paused"},
+				History: map[string]string{"Packing": "Picking"},
+			}},
+			{"unknown timer", Snapshot{
+				Version: SnapshotVersion,
+				Current: []string{"Paused"},
+				Entered: map[string]time.Time{"Gone": {}},
+			}},
+		}
+		for _, tt := range tests {
+			tt := tt
+			t.Run(tt.name, func(t *testing.T) {
+				m := pausable(t)
+				if err := m.Restore(tt.snap); err == nil {
+					t.Fatal("expected error")
+				}
+				if m.Current().Name() != "Picking" {
+					t.Fatalf("expected Picking, got %s", m.Current().Name())
+				}
+			})
+		}
+
+		m := orderStatus(t)
+		snap := Snapshot{Version: SnapshotVersion, Current: []string{"shipped", "pending"}}
+		if err := m.Restore(snap); err == nil {
+			t.Fatal("expected error for a state from another region")
+		}
+		if err := NewMachine().Restore(Snapshot{Version: SnapshotVersion}); err == nil {
+			t.Fatal("expected error for a machine with no start state")
+		}
+	})
+}
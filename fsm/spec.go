@@ -0,0 +1,223 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the declarative, serializable form of a machine.  Transition
+// guards are referenced by name and bound to TriggerFuncs from a Guards
+// registry when the spec is built.
+type Spec struct {
+	States      []StateSpec      `json:"states" yaml:"states"`
+	Start       string           `json:"start,omitempty" yaml:"start,omitempty"`
+	End         []string         `json:"end,omitempty" yaml:"end,omitempty"`
+	Regions     []RegionSpec     `json:"regions,omitempty" yaml:"regions,omitempty"`
+	Transitions []TransitionSpec `json:"transitions" yaml:"transitions"`
+}
+
+// RegionSpec describes an orthogonal region in a Spec
+type RegionSpec struct {
+	Name  string `json:"name" yaml:"name"`
+	Start string `json:"start" yaml:"start"`
+}
+
+// StateSpec describes a single state in a Spec.  States naming a Parent are
+// nested in it; the first one listed for a parent is its initial substate.
+type StateSpec struct {
+	Name   string `json:"name" yaml:"name"`
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty"`
+}
+
+// TransitionSpec describes a single transition in a Spec.  A transition has
+// exactly one of a Guard, which names a TriggerFunc; an After duration such
+// as "15m", which makes it a timed transition; or an On symbol, which makes
+// it accept values equal to that string.  When is the description of a
+// guarded transition and defaults to the guard name.  History may be
+// "shallow" or "deep" to target the history of the to state instead of the
+// state itself.  Priority orders the transitions out of a state, as set by
+// Priority.  Epsilon transitions cannot be described in a Spec.
+type TransitionSpec struct {
+	From     string `json:"from" yaml:"from"`
+	To       string `json:"to" yaml:"to"`
+	Guard    string `json:"guard,omitempty" yaml:"guard,omitempty"`
+	After    string `json:"after,omitempty" yaml:"after,omitempty"`
+	On       string `json:"on,omitempty" yaml:"on,omitempty"`
+	When     string `json:"when,omitempty" yaml:"when,omitempty"`
+	History  string `json:"history,omitempty" yaml:"history,omitempty"`
+	Priority int    `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+// Guards maps guard names used in a Spec to their TriggerFuncs
+type Guards map[string]TriggerFunc
+
+// LoadJSON decodes a JSON Spec from r and builds a validated machine from it
+func LoadJSON(r io.Reader, guards Guards) (*machine, error) {
+	var spec Spec
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("decode json spec: %w", err)
+	}
+
+	return spec.Build(guards)
+}
+
+// LoadYAML decodes a YAML Spec from r and builds a validated machine from it
+func LoadYAML(r io.Reader, guards Guards) (*machine, error) {
+	var spec Spec
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("decode yaml spec: %w", err)
+	}
+
+	return spec.Build(guards)
+}
+
+// Build creates a machine from the spec, binding each transition to the
+// named guard in guards.  If no start state is given, the from state of the
+// first transition is used.  The returned machine has been validated.
+func (s Spec) Build(guards Guards) (*machine, error) {
+	if len(s.Transitions) == 0 {
+		return nil, fmt.Errorf("spec has no transitions")
+	}
+
+	states := make(map[string]State, len(s.States))
+	declared := make([]State, 0, len(s.States))
+	for _, ss := range s.States {
+		if ss.Name == "" {
+			return nil, fmt.Errorf("spec has a state with no name")
+		}
+		if _, ok := states[ss.Name]; ok {
+			return nil, fmt.Errorf("duplicate state: '%s'", ss.Name)
+		}
+		states[ss.Name] = NewState(ss.Name)
+		declared = append(declared, states[ss.Name])
+	}
+
+	lookup := func(name string) (State, error) {
+		st, ok := states[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: '%s'", ErrUnknownState, name)
+		}
+		return st, nil
+	}
+
+	transitions := make([]Transition, 0, len(s.Transitions))
+	for i, ts := range s.Transitions {
+		from, err := lookup(ts.From)
+		if err != nil {
+			return nil, fmt.Errorf("transition %d: %w", i, err)
+		}
+		to, err := lookup(ts.To)
+		if err != nil {
+			return nil, fmt.Errorf("transition %d: %w", i, err)
+		}
+		t, err := ts.transition(from, guards)
+		if err != nil {
+			return nil, fmt.Errorf("transition %d: %w", i, err)
+		}
+		switch ts.History {
+		case "":
+		case "shallow":
+			to = History(to)
+		case "deep":
+			to = DeepHistory(to)
+		default:
+			return nil, fmt.Errorf("transition %d: unknown history: '%s'", i, ts.History)
+		}
+		transitions = append(transitions, Priority(t, ts.Priority).Then(to))
+	}
+
+	opts := []Option{WithStates(declared...), WithTransitions(transitions...)}
+	parents := make(map[string]string)
+	for _, ss := range s.States {
+		if ss.Parent == "" {
+			continue
+		}
+		parent, err := lookup(ss.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("state '%s': %w", ss.Name, err)
+		}
+		parents[ss.Name] = ss.Parent
+		for p := ss.Parent; p != ""; p = parents[p] {
+			if p == ss.Name {
+				return nil, fmt.Errorf("state '%s' cannot be nested in itself", ss.Name)
+			}
+		}
+		opts = append(opts, WithSubstates(parent, states[ss.Name]))
+	}
+
+	regions := make(map[string]bool, len(s.Regions))
+	for _, rs := range s.Regions {
+		if rs.Name == "" || regions[rs.Name] {
+			return nil, fmt.Errorf("regions must have unique names: '%s'", rs.Name)
+		}
+		regions[rs.Name] = true
+		start, err := lookup(rs.Start)
+		if err != nil {
+			return nil, fmt.Errorf("region '%s': %w", rs.Name, err)
+		}
+		opts = append(opts, WithRegion(rs.Name, start))
+	}
+
+	m := NewMachine(opts...)
+	if s.Start != "" {
+		if err := m.SetStart(s.Start); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.End) > 0 {
+		if err := m.SetEndStates(s.End...); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// transition creates the transition out of from described by ts, without its
+// to state
+func (ts TransitionSpec) transition(from State, guards Guards) (Transition, error) {
+	kinds := 0
+	for _, set := range []bool{ts.Guard != "", ts.After != "", ts.On != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return nil, fmt.Errorf("transition needs exactly one of guard, after and on")
+	}
+	if ts.When != "" && ts.Guard == "" {
+		return nil, fmt.Errorf("when only describes guarded transitions")
+	}
+
+	switch {
+	case ts.After != "":
+		d, err := time.ParseDuration(ts.After)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid after: '%s'", ts.After)
+		}
+		return After(from, d), nil
+	case ts.On != "":
+		return On(from, ts.On), nil
+	}
+
+	f, ok := guards[ts.Guard]
+	if !ok || f == nil {
+		return nil, fmt.Errorf("unknown guard: '%s'", ts.Guard)
+	}
+	desc := ts.When
+	if desc == "" {
+		desc = ts.Guard
+	}
+	return from.When(desc, f), nil
+}
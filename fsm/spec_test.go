@@ -0,0 +1,314 @@
+package fsm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const specJSON = `{
+	"states": [{"name": "start"}, {"name": "a"}, {"name": "b"}, {"name": "c"}],
+	"start": "start",
+	"end": ["c"],
+	"transitions": [
+		{"from": "start", "to": "a", "guard": "is_a", "when": "starts with a"},
+		{"from": "a", "to": "a", "guard": "is_a"},
+		{"from": "a", "to": "b", "guard": "is_b"},
+		{"from": "b", "to": "c", "guard": "is_c"}
+	]
+}`
+
+const specYAML = `
+states:
+  - name: start
+  - name: a
+  - name: b
+  - name: c
+start: start
+end: [c]
+transitions:
+  - {from: start, to: a, guard: is_a, when: starts with a}
+  - {from: a, to: a, guard: is_a}
+  - {from: a, to: b, guard: is_b}
+  - {from: b, to: c, guard: is_c}
+`
+
+func specGuards() Guards {
+	return Guards{
+		"is_a": byteIs('a'),
+		"is_b": byteIs('b'),
+		"is_c": byteIs('c'),
+	}
+}
+
+func TestLoad(t *testing.T) {
+	loaders := map[string]func() (*machine, error){
+		"json": func() (*machine, error) {
+			return LoadJSON(strings.NewReader(specJSON), specGuards())
+		},
+		"yaml": func() (*machine, error) {
+			return LoadYAML(strings.NewReader(specYAML), specGuards())
+		},
+	}
+
+	for name, load := range loaders {
+		load := load
+		t.Run(name, func(t *testing.T) {
+			m, err := load()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			s := "aabc"
+			for i := 0; i < len(s); i++ {
+				changed, err := m.Update(ctx, s[i])
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !changed {
+					t.Fatal("change expected")
+				}
+			}
+			if m.Current().Name() != "c" || !m.IsEndState() {
+				t.Fatalf("expected end state c, got %s", m.Current().Name())
+			}
+		})
+	}
+}
+
+func TestSpecBuild(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+	}{
+		{
+			name: "no transitions",
+			spec: Spec{States: []StateSpec{{Name: "a"}}},
+		},
+		{
+			name: "duplicate state",
+			spec: Spec{
+				States:      []StateSpec{{Name: "a"}, {Name: "a"}},
+				Transitions: []TransitionSpec{{From: "a", To: "a", Guard: "is_a"}},
+			},
+		},
+		{
+			name: "unknown state",
+			spec: Spec{
+				States:      []StateSpec{{Name: "a"}},
+				Transitions: []TransitionSpec{{From: "a", To: "b", Guard: "is_a"}},
+			},
+		},
+		{
+			name: "unknown guard",
+			spec: Spec{
+				States:      []StateSpec{{Name: "a"}, {Name: "b"}},
+				Transitions: []TransitionSpec{{From: "a", To: "b", Guard: "is_z"}},
+			},
+		},
+		{
+			name: "unknown end state",
+			spec: Spec{
+				States:      []StateSpec{{Name: "a"}, {Name: "b"}},
+				End:         []string{"c"},
+				Transitions: []TransitionSpec{{From: "a", To: "b", Guard: "is_a"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.spec.Build(specGuards()); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	if _, err := LoadJSON(strings.NewReader(`{"stats": []}`), specGuards()); err == nil {
+		t.Fatal("expected json error")
+	}
+	if _, err := LoadYAML(strings.NewReader("stats: []\n"), specGuards()); err == nil {
+		t.Fatal("expected yaml error")
+	}
+}
+
+func TestSpecSubstates(t *testing.T) {
+	spec := Spec{
+		States: []StateSpec{
+			{Name: "idle"},
+			{Name: "active"},
+			{Name: "picking", Parent: "active"},
+			{Name: "packing", Parent: "active"},
+			{Name: "cancelled"},
+		},
+		End: []string{"cancelled"},
+		Transitions: []TransitionSpec{
+			{From: "idle", To: "active", Guard: "is_a"},
+			{From: "picking", To: "packing", Guard: "is_b"},
+			{From: "active", To: "cancelled", Guard: "is_c"},
+		},
+	}
+
+	m, err := spec.Build(specGuards())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	s := "abc"
+	for i := 0; i < len(s); i++ {
+		if changed, err := m.Update(ctx, s[i]); err != nil || !changed {
+			t.Fatalf("%c: changed=%v err=%v", s[i], changed, err)
+		}
+	}
+	if m.Current().Name() != "cancelled" {
+		t.Fatalf("expected cancelled, got %s", m.Current().Name())
+	}
+
+	for _, parent := range []string{"nowhere", "picking"} {
+		spec.States[2].Parent = parent
+		if _, err := spec.Build(specGuards()); err == nil {
+			t.Fatalf("%s: expected error", parent)
+		}
+	}
+	spec.States[1].Parent = "packing"
+	spec.States[2].Parent = "active"
+	if _, err := spec.Build(specGuards()); err == nil {
+		t.Fatal("cycle: expected error")
+	}
+}
+
+func TestSpecRegions(t *testing.T) {
+	spec := Spec{
+		States:  []StateSpec{{Name: "unpaid"}, {Name: "paid"}, {Name: "pending"}, {Name: "shipped"}},
+		End:     []string{"paid", "shipped"},
+		Regions: []RegionSpec{{Name: "fulfilment", Start: "pending"}},
+		Transitions: []TransitionSpec{
+			{From: "unpaid", To: "paid", Guard: "is_a"},
+			{From: "pending", To: "shipped", Guard: "is_b"},
+		},
+	}
+
+	m, err := spec.Build(specGuards())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, b := range []byte("ab") {
+		if _, err := m.Update(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !m.IsEndState() {
+		t.Fatalf("expected end state, got %v", names(m.CurrentStates()))
+	}
+
+	for _, regions := range [][]RegionSpec{
+		{{Name: "", Start: "pending"}},
+		{{Name: "r", Start: "nowhere"}},
+		{{Name: "r", Start: "pending"}, {Name: "r", Start: "pending"}},
+	} {
+		spec.Regions = regions
+		if _, err := spec.Build(specGuards()); err == nil {
+			t.Fatalf("%v: expected error", regions)
+		}
+	}
+}
+
+func TestSpecHistory(t *testing.T) {
+	spec := Spec{
+		States: []StateSpec{
+			{Name: "active"},
+			{Name: "picking", Parent: "active"},
+			{Name: "packing", Parent: "active"},
+			{Name: "paused"},
+		},
+		Transitions: []TransitionSpec{
+			{From: "picking", To: "packing", Guard: "is_a"},
+			{From: "active", To: "paused", Guard: "is_b"},
+			{From: "paused", To: "active", Guard: "is_c", History: "shallow"},
+		},
+	}
+
+	m, err := spec.Build(specGuards())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, b := range []byte("abc") {
+		if _, err := m.Update(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Current().Name() != "packing" {
+		t.Fatalf("expected packing, got %s", m.Current().Name())
+	}
+
+	spec.Transitions[2].History = "sideways"
+	if _, err := spec.Build(specGuards()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSpecPriority(t *testing.T) {
+	spec := Spec{
+		States: []StateSpec{{Name: "start"}, {Name: "a"}, {Name: "b"}},
+		Transitions: []TransitionSpec{
+			{From: "start", To: "a", Guard: "is_a"},
+			{From: "start", To: "b", Guard: "is_a", Priority: 1},
+		},
+	}
+
+	m, err := spec.Build(specGuards())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Update(context.Background(), byte('a')); err != nil {
+		t.Fatal(err)
+	}
+	if m.Current().Name() != "b" {
+		t.Fatalf("expected b, got %s", m.Current().Name())
+	}
+}
+
+func TestSpecTimedAndSymbolTransitions(t *testing.T) {
+	doc := `
+states: [{name: pending}, {name: paid}, {name: expired}]
+transitions:
+  - {from: pending, to: paid, on: pay}
+  - {from: pending, to: expired, after: 15m}
+`
+	m, err := LoadYAML(strings.NewReader(doc), specGuards())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := m.transitions[m.lookup("pending").Id()]
+	if sym, ok := symbolOf(tt[0]); !ok || sym != "pay" {
+		t.Fatalf("unexpected symbol: %v", sym)
+	}
+	if d := timeoutOf(tt[1]); d != 15*time.Minute {
+		t.Fatalf("unexpected timeout: %s", d)
+	}
+	if _, err := m.Update(context.Background(), "pay"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Current().Name() != "paid" {
+		t.Fatalf("expected paid, got %s", m.Current().Name())
+	}
+
+	for _, ts := range []TransitionSpec{
+		{From: "pending", To: "paid"},
+		{From: "pending", To: "paid", Guard: "is_a", On: "pay"},
+		{From: "pending", To: "paid", After: "soon"},
+		{From: "pending", To: "paid", On: "pay", When: "paying"},
+	} {
+		spec := Spec{States: []StateSpec{{Name: "pending"}, {Name: "paid"}}, Transitions: []TransitionSpec{ts}}
+		if _, err := spec.Build(specGuards()); err == nil {
+			t.Fatalf("expected error for %+v", ts)
+		}
+	}
+}
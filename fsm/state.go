@@ -2,31 +2,45 @@ package fsm
 
 import (
 	"context"
-	"sync/atomic"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"time"
 )
 
 //go:generate slicify Transition all
 
-var gid uint64                    // nolint:gochecknoglobals
-var lock = make(chan struct{}, 1) // nolint:gochecknoglobals
-
-func resetGID() {
-	atomic.StoreUint64(&gid, 0)
+// mkID derives an ID from the names that identify a state or transition, so
+// that the same machine definition has the same IDs in every process
+func mkID(names ...string) uint64 {
+	h := fnv.New64a()
+	for i, name := range names {
+		if i > 0 {
+			_, _ = h.Write([]byte{0})
+		}
+		_, _ = h.Write([]byte(name))
+	}
+	return h.Sum64()
 }
 
-func mkID() uint64 {
-	lock <- struct{}{}
-	defer func() {
-		<-lock
-	}()
-	id := atomic.AddUint64(&gid, 1)
-	return id
+// instance tells apart states that share a name, and so share an ID
+func instance(s State) interface{} {
+	if ms, ok := s.(machineState); ok {
+		return ms.self
+	}
+	return s.Id()
 }
 
 type Trigger uint
 
 type TriggerFunc func(context.Context, interface{}) (bool, error)
 
+// ActionFunc is a side effect run by the machine when a state is entered or
+// exited, or when a transition is taken.  Returning an error aborts the
+// transition.
+type ActionFunc func(context.Context, interface{}) error
+
 type Identifier interface {
 	Id() uint64
 }
@@ -47,14 +61,33 @@ type Transition interface {
 }
 
 type machineState struct {
-	name string
-	id   uint64
+	name    string
+	id      uint64
+	self    *int
+	onEnter []ActionFunc
+	onExit  []ActionFunc
 }
 
 type StateOption func(machineState) machineState
 
+// OnEnter adds an action that runs whenever the machine enters the state
+func OnEnter(f ActionFunc) StateOption {
+	return func(s machineState) machineState {
+		s.onEnter = append(s.onEnter, f)
+		return s
+	}
+}
+
+// OnExit adds an action that runs whenever the machine leaves the state
+func OnExit(f ActionFunc) StateOption {
+	return func(s machineState) machineState {
+		s.onExit = append(s.onExit, f)
+		return s
+	}
+}
+
 func NewState(name string, options ...StateOption) State {
-	s := machineState{id: mkID(), name: name}
+	s := machineState{id: mkID(name), self: new(int), name: name}
 	for _, f := range options {
 		s = f(s)
 	}
@@ -67,19 +100,155 @@ func (s machineState) Name() string {
 }
 
 func (s machineState) When(desc string, f TriggerFunc) Transition {
-	return &edge{id: mkID(), from: s, f: f, desc: desc}
+	return &edge{id: mkID(s.name, desc), from: s, f: f, desc: desc}
 }
 
 func (s machineState) Id() uint64 {
 	return s.id
 }
 
+// Enter runs the state's enter actions
+func (s machineState) Enter(ctx context.Context, v interface{}) error {
+	return runActions(ctx, v, s.onEnter)
+}
+
+// Exit runs the state's exit actions
+func (s machineState) Exit(ctx context.Context, v interface{}) error {
+	return runActions(ctx, v, s.onExit)
+}
+
+// enter runs the enter actions of s.  States only have actions if they
+// implement Enter, as the states returned by NewState do.
+func enter(ctx context.Context, s State, v interface{}) error {
+	if a, ok := s.(interface {
+		Enter(context.Context, interface{}) error
+	}); ok {
+		return a.Enter(ctx, v)
+	}
+	return nil
+}
+
+// exit runs the exit actions of s, if it implements Exit
+func exit(ctx context.Context, s State, v interface{}) error {
+	if a, ok := s.(interface {
+		Exit(context.Context, interface{}) error
+	}); ok {
+		return a.Exit(ctx, v)
+	}
+	return nil
+}
+
+// act runs the actions of t, if it implements Act
+func act(ctx context.Context, t Transition, v interface{}) error {
+	if a, ok := t.(interface {
+		Act(context.Context, interface{}) error
+	}); ok {
+		return a.Act(ctx, v)
+	}
+	return nil
+}
+
+// quiet reports whether taking t, exiting and entering the given states,
+// runs no actions, so that the machine can skip preparing the context that
+// actions are run with.  States and transitions of other types are assumed
+// to have actions if they implement Enter, Exit or Act.
+func quiet(exits []State, t Transition, enters []State) bool {
+	switch t := t.(type) {
+	case *edge:
+		if len(t.do) > 0 {
+			return false
+		}
+	case interface {
+		Act(context.Context, interface{}) error
+	}:
+		return false
+	}
+	for _, s := range exits {
+		switch s := s.(type) {
+		case machineState:
+			if len(s.onExit) > 0 {
+				return false
+			}
+		case interface {
+			Exit(context.Context, interface{}) error
+		}:
+			return false
+		}
+	}
+	for _, s := range enters {
+		switch s := s.(type) {
+		case machineState:
+			if len(s.onEnter) > 0 {
+				return false
+			}
+		case interface {
+			Enter(context.Context, interface{}) error
+		}:
+			return false
+		}
+	}
+	return true
+}
+
+func runActions(ctx context.Context, v interface{}, actions []ActionFunc) error {
+	for _, f := range actions {
+		if err := f(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type edge struct {
-	desc string
-	from State
-	to   State
-	f    TriggerFunc
-	id   uint64
+	desc  string
+	from  State
+	to    State
+	f     TriggerFunc
+	do    []ActionFunc
+	id    uint64
+	after time.Duration
+	prio  int
+	sym   interface{}
+	keyed bool
+	eps   bool
+}
+
+func never(context.Context, interface{}) (bool, error) {
+	return false, nil
+}
+
+// on returns a transition out of from that accepts values equal to symbol
+func on(from State, symbol interface{}) Transition {
+	if symbol == nil || !reflect.TypeOf(symbol).Comparable() {
+		panic(fmt.Sprintf("symbol must be comparable: %#v", symbol))
+	}
+	desc := describeSymbol(symbol)
+	return &edge{id: mkID(from.Name(), desc), from: from, desc: desc, sym: symbol, keyed: true, f: func(_ context.Context, v interface{}) (bool, error) {
+		return v == symbol, nil
+	}}
+}
+
+// epsilonDesc describes every epsilon transition
+const epsilonDesc = "ε"
+
+// epsilon returns an epsilon transition out of from
+func epsilon(from State) Transition {
+	return &edge{id: mkID(from.Name(), epsilonDesc), from: from, desc: epsilonDesc, eps: true, f: never}
+}
+
+// describeSymbol renders a symbol as a transition description.  Bytes and
+// runes are quoted, so that 'a' is not described as 97.
+func describeSymbol(symbol interface{}) string {
+	switch v := symbol.(type) {
+	case string:
+		return v
+	case byte:
+		return strconv.QuoteRune(rune(v))
+	case rune:
+		return strconv.QuoteRune(v)
+	default:
+		return fmt.Sprint(v)
+	}
 }
 
 func (e *edge) Id() uint64 {
@@ -100,9 +269,98 @@ func (e *edge) To() State {
 
 func (e *edge) Then(s State) Transition {
 	e.to = s
+	if s != nil {
+		e.id = mkID(e.from.Name(), e.desc, s.Name())
+	}
 	return e
 }
 
+// Priority returns the priority set with the package-level Priority
+func (e *edge) Priority() int {
+	return e.prio
+}
+
 func (e *edge) Go(ctx context.Context, v interface{}) (bool, error) {
 	return e.f(ctx, v)
 }
+
+// Timeout returns how long the machine waits before taking the transition,
+// or 0 if it is not timed
+func (e *edge) Timeout() time.Duration {
+	return e.after
+}
+
+// Symbol returns the symbol the transition is keyed on, if it was created
+// with On
+func (e *edge) Symbol() (interface{}, bool) {
+	return e.sym, e.keyed
+}
+
+// Epsilon reports whether the transition was created with Epsilon
+func (e *edge) Epsilon() bool {
+	return e.eps
+}
+
+// Act runs the transition's actions
+func (e *edge) Act(ctx context.Context, v interface{}) error {
+	return runActions(ctx, v, e.do)
+}
+
+// After returns a transition out of s that fires automatically once the
+// machine has been in s for d.  Timed transitions never match a value passed
+// to Update; they are fired by Tick, which Start calls in the background.  A
+// transition of another type is timed if it implements
+// Timeout() time.Duration.
+func After(s State, d time.Duration) Transition {
+	desc := fmt.Sprintf("after %s", d)
+	return &edge{id: mkID(s.Name(), desc), from: s, desc: desc, after: d, f: never}
+}
+
+// On returns a transition out of s that accepts values equal to symbol.  The
+// symbol must be of a comparable type.  Transitions keyed on a symbol are
+// found by looking the value up rather than by calling trigger functions, and
+// take precedence over the transitions out of the same state that use them
+// and have the same priority.  A transition of another type is keyed if it
+// implements Symbol() (interface{}, bool).
+func On(s State, symbol interface{}) Transition {
+	return on(s, symbol)
+}
+
+// Epsilon returns a transition out of s that is followed without consuming
+// a value.  Only NFA machines follow epsilon transitions; see WithNFA.  A
+// transition of another type is an epsilon transition if it implements
+// Epsilon() bool and returns true.
+func Epsilon(s State) Transition {
+	return epsilon(s)
+}
+
+// Do adds an action to t that runs when it is taken, after the from state has
+// been exited and before the to state is entered.  t must have been created
+// by this package; a transition of another type has actions if it implements
+// Act(context.Context, interface{}) error.
+func Do(t Transition, f ActionFunc) Transition {
+	e := own(t, "Do")
+	e.do = append(e.do, f)
+	return e
+}
+
+// Priority sets the priority of t.  Transitions out of a state are evaluated
+// from the highest priority to the lowest, and in the order they were added
+// when their priorities are equal, except that transitions created with On
+// come before those with trigger functions.  The default is 0.  t must have
+// been created by this package; a transition of another type has a priority
+// if it implements Priority() int.
+func Priority(t Transition, p int) Transition {
+	e := own(t, "Priority")
+	e.prio = p
+	return e
+}
+
+// own returns t as an edge, or panics if it was not created by this package
+func own(t Transition, op string) *edge {
+	e, ok := t.(*edge)
+	if !ok {
+		panic(fmt.Sprintf("%s: transition '%s' was not created by this package", op, t.Description()))
+	}
+	return e
+}
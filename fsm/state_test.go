@@ -1,26 +1,223 @@
 package fsm
 
 import (
-	"sync"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMkID(t *testing.T) {
-	// test with race detector
-	const numRounds = 1000
-	resetGID()
-	var wg sync.WaitGroup
-	wg.Add(numRounds)
-	for i := 0; i < numRounds; i++ {
-		go func() {
-			mkID()
-			wg.Done()
-		}()
+	if NewState("a").Id() != NewState("a").Id() {
+		t.Fatal("states with the same name must have the same ID")
+	}
+	if NewState("a").Id() == NewState("b").Id() {
+		t.Fatal("states with different names must have different IDs")
+	}
+	if mkID("ab", "c") == mkID("a", "bc") {
+		t.Fatal("names must be separated")
+	}
+
+	// known value, so IDs stay stable across releases
+	if id := NewState("a").Id(); id != 0xaf63dc4c8601ec8c {
+		t.Fatalf("unexpected ID for 'a': %#x", id)
 	}
-	wg.Wait()
 
-	id := mkID()
-	if id != numRounds+1 {
-		t.Fatalf("mkID: expected %d, got %d", numRounds+1, id)
+	a, b, c := NewState("a"), NewState("b"), NewState("c")
+	ids := make(map[uint64]bool)
+	for _, tr := range []Transition{
+		a.When("x", byteIs('x')).Then(b),
+		a.When("x", byteIs('x')).Then(c),
+		a.When("y", byteIs('y')).Then(b),
+		b.When("x", byteIs('x')).Then(b),
+		History(a).When("x", byteIs('x')).Then(b),
+		After(a, time.Second).Then(b),
+		On(a, 'x').Then(b),
+		On(a, 'y').Then(b),
+		Epsilon(a).Then(b),
+		Epsilon(a).Then(c),
+	} {
+		if ids[tr.Id()] {
+			t.Fatalf("duplicate ID for '%s' from '%s'", tr.Description(), tr.From().Name())
+		}
+		ids[tr.Id()] = true
 	}
+	if a.When("x", byteIs('x')).Then(b).Id() != NewState("a").When("x", nil).Then(NewState("b")).Id() {
+		t.Fatal("equal transitions must have the same ID")
+	}
+	if History(a).Id() == DeepHistory(a).Id() || History(a).Id() == a.Id() {
+		t.Fatal("history states must have their own IDs")
+	}
+}
+
+func TestActions(t *testing.T) {
+	var calls []string
+	record := func(name string, err error) ActionFunc {
+		return func(_ context.Context, v interface{}) error {
+			calls = append(calls, fmt.Sprintf("%s(%c)", name, v))
+			return err
+		}
+	}
+
+	t.Run("order", func(t *testing.T) {
+		calls = nil
+		s1 := NewState("s1", OnEnter(record("enter s1", nil)), OnExit(record("exit s1", nil)))
+		s2 := NewState("s2", OnEnter(record("enter s2", nil)), OnEnter(record("enter s2 again", nil)))
+		m := NewMachine(WithTransitions(
+			Do(s1.When("x", byteIs('x')), record("x", nil)).Then(s2),
+		))
+
+		changed, err := m.Update(context.Background(), byte('x'))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changed {
+			t.Fatal("change expected")
+		}
+
+		want := []string{"exit s1(x)", "x(x)", "enter s2(x)", "enter s2 again(x)"}
+		if !reflect.DeepEqual(calls, want) {
+			t.Fatalf("expected %v, got %v", want, calls)
+		}
+	})
+
+	t.Run("self transition exits and enters", func(t *testing.T) {
+		calls = nil
+		s1 := NewState("s1", OnEnter(record("enter", nil)), OnExit(record("exit", nil)))
+		m := NewMachine(WithTransitions(s1.When("x", byteIs('x')).Then(s1)))
+
+		if _, err := m.Update(context.Background(), byte('x')); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"exit(x)", "enter(x)"}
+		if !reflect.DeepEqual(calls, want) {
+			t.Fatalf("expected %v, got %v", want, calls)
+		}
+	})
+
+	t.Run("errors abort", func(t *testing.T) {
+		errAction := errors.New("action failed")
+		tests := []struct {
+			name string
+			s1   []StateOption
+			do   ActionFunc
+			s2   []StateOption
+			want []string
+		}{
+			{
+				name: "exit",
+				s1:   []StateOption{OnExit(record("exit", errAction))},
+				do:   record("x", nil),
+				want: []string{"exit(x)"},
+			},
+			{
+				name: "transition",
+				do:   record("x", errAction),
+				s2:   []StateOption{OnEnter(record("enter", nil))},
+				want: []string{"x(x)"},
+			},
+			{
+				name: "enter",
+				do:   record("x", nil),
+				s2:   []StateOption{OnEnter(record("enter", errAction))},
+				want: []string{"x(x)", "enter(x)"},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				calls = nil
+				s1 := NewState("s1", tt.s1...)
+				s2 := NewState("s2", tt.s2...)
+				m := NewMachine(WithTransitions(Do(s1.When("x", byteIs('x')), tt.do).Then(s2)))
+
+				changed, err := m.Update(context.Background(), byte('x'))
+				if !errors.Is(err, errAction) {
+					t.Fatalf("expected action error, got %v", err)
+				}
+				if changed {
+					t.Fatal("unexpected change")
+				}
+				if m.Current().Name() != "s1" {
+					t.Fatalf("expected s1, got %s", m.Current().Name())
+				}
+				if !reflect.DeepEqual(calls, tt.want) {
+					t.Fatalf("expected %v, got %v", tt.want, calls)
+				}
+			})
+		}
+	})
+	t.Run("states without actions", func(t *testing.T) {
+		calls = nil
+		s1 := plain("s1")
+		s2 := NewState("s2", OnEnter(record("enter", nil)), OnExit(record("exit", nil)))
+		m := NewMachine(WithTransitions(
+			s1.When("x", byteIs('x')).Then(s2),
+			s2.When("y", byteIs('y')).Then(s1),
+		))
+
+		for _, v := range []byte("xy") {
+			if _, err := m.Update(context.Background(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := []string{"enter(x)", "exit(y)"}; !reflect.DeepEqual(calls, want) {
+			t.Fatalf("expected %v, got %v", want, calls)
+		}
+	})
+}
+
+// plain is a State implemented outside of NewState, without Enter or Exit
+type plain string
+
+func (p plain) Id() uint64 {
+	return mkID(string(p))
+}
+
+func (p plain) Name() string {
+	return string(p)
+}
+
+func (p plain) When(desc string, f TriggerFunc) Transition {
+	return &edge{id: mkID(string(p), desc), from: p, f: f, desc: desc}
+}
+
+// prioritised is a Transition implemented outside of this package's
+// builders, which opts in to priorities
+type prioritised struct {
+	Transition
+	prio int
+}
+
+func (p prioritised) Priority() int {
+	return p.prio
+}
+
+func TestForeignTransitions(t *testing.T) {
+	a, low, high := NewState("a"), NewState("low"), NewState("high")
+
+	t.Run("optional methods", func(t *testing.T) {
+		m := NewMachine(WithTransitions(
+			a.When("low", byteIs('x')).Then(low),
+			prioritised{Transition: a.When("high", byteIs('x')).Then(high), prio: 1},
+		))
+		if _, err := m.Update(context.Background(), byte('x')); err != nil {
+			t.Fatal(err)
+		}
+		if m.Current().Name() != "high" {
+			t.Fatalf("expected high, got %s", m.Current().Name())
+		}
+	})
+	t.Run("builders", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected a panic")
+			}
+		}()
+		Do(prioritised{Transition: a.When("x", byteIs('x')).Then(low)}, func(context.Context, interface{}) error {
+			return nil
+		})
+	})
 }
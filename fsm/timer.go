@@ -0,0 +1,265 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Clock is the source of time for timed transitions.  It is injectable with
+// WithClock so that timed behaviour can be tested deterministically.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock sets the clock used for timed transitions.  The default is the
+// system clock.
+func WithClock(c Clock) Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.clock = c
+	}
+}
+
+// WithErrorHandler sets a function that receives errors returned while
+// firing timed transitions in the background
+func WithErrorHandler(f func(error)) Option {
+	return func(m *machine) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.onError = f
+	}
+}
+
+// Start fires timed transitions in the background until ctx is done or Stop
+// is called.  It returns an error if the machine is already started.
+func (m *machine) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancel != nil {
+		return errors.New("machine already started")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.cancel = cancel
+	m.wake = make(chan struct{}, 1)
+	m.done = make(chan struct{})
+
+	go m.run(ctx, m.wake, m.done)
+
+	return nil
+}
+
+// Stop stops firing timed transitions and waits for the background goroutine
+// started by Start to exit.  It is a no-op if the machine is not started.
+func (m *machine) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.wake, m.done = nil, nil, nil
+	m.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Tick fires every timed transition that is due according to the machine's
+// clock, and reports whether any region changed state.  The value passed to
+// the actions of a timed transition is the current time.  Start calls Tick
+// in the background; it can also be called directly.
+func (m *machine) Tick(ctx context.Context) (bool, error) {
+	defer m.publish()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+
+	now := m.now()
+	r, err := m.step(ctx, now, func(_ context.Context, curr State) (Transition, error) {
+		return m.due(curr, now), nil
+	})
+	return r.Changed(), err
+}
+
+func (m *machine) run(ctx context.Context, wake <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		var timer <-chan time.Time
+		if d, ok := m.nextTimeout(); ok {
+			m.mu.RLock()
+			c := m.timeSource()
+			m.mu.RUnlock()
+			timer = c.After(d)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-timer:
+			if _, err := m.Tick(ctx); err != nil && ctx.Err() == nil {
+				m.mu.RLock()
+				onError := m.onError
+				m.mu.RUnlock()
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}
+}
+
+// nextTimeout returns how long until the earliest timed transition out of
+// the active states is due
+func (m *machine) nextTimeout() (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var next time.Time
+	for _, curr := range m.active() {
+		for _, s := range m.ancestors(curr) {
+			for _, t := range m.transitions[s.Id()] {
+				d := timeoutOf(t)
+				if d <= 0 {
+					continue
+				}
+				at := m.since(s, now).Add(d)
+				if next.IsZero() || at.Before(next) {
+					next = at
+				}
+			}
+		}
+	}
+
+	if next.IsZero() {
+		return 0, false
+	}
+	if next.Before(now) {
+		return 0, true
+	}
+	return next.Sub(now), true
+}
+
+// due returns the timed transition out of curr or its enclosing states that
+// became due first, or nil if none is due at now.  The caller must hold m.mu.
+func (m *machine) due(curr State, now time.Time) Transition {
+	var (
+		first Transition
+		at    time.Time
+	)
+	for _, s := range m.ancestors(curr) {
+		for _, t := range m.transitions[s.Id()] {
+			d := timeoutOf(t)
+			if d <= 0 {
+				continue
+			}
+			deadline := m.since(s, now).Add(d)
+			if deadline.After(now) {
+				continue
+			}
+			if first == nil || deadline.Before(at) {
+				first, at = t, deadline
+			}
+		}
+	}
+	return first
+}
+
+// since returns when s was entered.  States whose entry was not recorded,
+// such as the start states of regions added after the machine was created,
+// are treated as entered at now.  The caller must hold m.mu.
+func (m *machine) since(s State, now time.Time) time.Time {
+	if m.entered == nil {
+		m.entered = make(map[uint64]time.Time)
+	}
+	at, ok := m.entered[s.Id()]
+	if !ok {
+		m.entered[s.Id()] = now
+		at = now
+	}
+	return at
+}
+
+// enterStart records the states the machine starts in, from the start state
+// of every region down to its initial leaf state, as entered now, and
+// forgets any other entry times.  The caller must hold m.mu.
+func (m *machine) enterStart() {
+	m.entered = nil
+	if m.start == nil {
+		return
+	}
+	now := m.now()
+	for _, root := range m.roots() {
+		m.track(now, nil, m.ancestors(m.descend(root)))
+	}
+}
+
+// track records when states were exited and entered.  The caller must hold
+// m.mu.
+func (m *machine) track(now time.Time, exits, enters []State) {
+	for _, s := range exits {
+		delete(m.entered, s.Id())
+	}
+	for _, s := range enters {
+		if m.entered == nil {
+			m.entered = make(map[uint64]time.Time)
+		}
+		m.entered[s.Id()] = now
+	}
+}
+
+// notify wakes the background goroutine so it can recompute the next
+// timeout.  The caller must hold m.mu.
+func (m *machine) notify() {
+	if m.wake == nil {
+		return
+	}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// now returns the current time from the machine's clock.  The caller must
+// hold m.mu.
+func (m *machine) now() time.Time {
+	return m.timeSource().Now()
+}
+
+// timeSource returns the machine's clock.  The caller must hold m.mu.
+func (m *machine) timeSource() Clock {
+	if m.clock == nil {
+		return realClock{}
+	}
+	return m.clock
+}
+
+func timeoutOf(t Transition) time.Duration {
+	if tt, ok := t.(interface{ Timeout() time.Duration }); ok {
+		return tt.Timeout()
+	}
+	return 0
+}
@@ -0,0 +1,342 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualClock only moves when advanced
+type manualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []manualWaiter
+}
+
+type manualWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, manualWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+func (c *manualClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+func eventually(t *testing.T, f func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// payment expires if it is not paid within 15 minutes
+func payment(t *testing.T, clock Clock, opts ...Option) *machine {
+	t.Helper()
+
+	awaiting := NewState("AwaitingPayment")
+	paid := NewState("Paid")
+	expired := NewState("Expired")
+
+	m := NewMachine(append([]Option{
+		WithClock(clock),
+		WithTransitions(
+			awaiting.When("pay", strIs("pay")).Then(paid),
+			awaiting.When("remind", strIs("remind")).Then(awaiting),
+			After(awaiting, 15*time.Minute).Then(expired),
+		),
+	}, opts...)...)
+	if err := m.SetEndStates("Paid", "Expired"); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestTick(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fires when due", func(t *testing.T) {
+		clock := newManualClock()
+		m := payment(t, clock)
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, step := range []struct {
+			advance time.Duration
+			want    bool
+		}{
+			{0, false},
+			{14 * time.Minute, false},
+			{time.Minute, true},
+		} {
+			clock.Advance(step.advance)
+			changed, err := m.Tick(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != step.want {
+				t.Fatalf("after %s: expected change %v", step.advance, step.want)
+			}
+		}
+		if m.Current().Name() != "Expired" {
+			t.Fatalf("expected Expired, got %s", m.Current().Name())
+		}
+	})
+
+	t.Run("timers start with the machine", func(t *testing.T) {
+		clock := newManualClock()
+		m := payment(t, clock)
+		if at := m.Snapshot().Entered["AwaitingPayment"]; !at.Equal(clock.Now()) {
+			t.Fatalf("unexpected entry time: %v", at)
+		}
+		clock.Advance(20 * time.Minute)
+		if changed, err := m.Tick(ctx); !changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+
+		if err := m.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(20 * time.Minute)
+		if changed, err := m.Tick(ctx); !changed || err != nil {
+			t.Fatalf("after reset: changed=%v err=%v", changed, err)
+		}
+
+		def, err := m.Define()
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := def.NewInstance()
+		clock.Advance(20 * time.Minute)
+		if changed, err := i.Tick(ctx); !changed || err != nil {
+			t.Fatalf("instance: changed=%v err=%v", changed, err)
+		}
+	})
+
+	t.Run("timed transitions ignore values", func(t *testing.T) {
+		m := payment(t, newManualClock())
+		if changed, err := m.Update(ctx, "after 15m0s"); changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+	})
+
+	t.Run("re-entering a state restarts its timer", func(t *testing.T) {
+		clock := newManualClock()
+		m := payment(t, clock)
+		if _, err := m.Tick(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(10 * time.Minute)
+		if _, err := m.Update(ctx, "remind"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(10 * time.Minute)
+		if changed, _ := m.Tick(ctx); changed {
+			t.Fatal("unexpected change")
+		}
+		clock.Advance(5 * time.Minute)
+		if changed, _ := m.Tick(ctx); !changed {
+			t.Fatal("change expected")
+		}
+	})
+
+	t.Run("enclosing state timers survive substate changes", func(t *testing.T) {
+		clock := newManualClock()
+		session := NewState("Session")
+		browsing := NewState("Browsing")
+		checkout := NewState("Checkout")
+		timedOut := NewState("TimedOut")
+		m := NewMachine(
+			WithClock(clock),
+			WithTransitions(
+				After(session, 30*time.Minute).Then(timedOut),
+				browsing.When("checkout", strIs("checkout")).Then(checkout),
+			),
+			WithSubstates(session, browsing, checkout),
+		)
+		if _, err := m.Tick(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(20 * time.Minute)
+		if _, err := m.Update(ctx, "checkout"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(10 * time.Minute)
+		if changed, _ := m.Tick(ctx); !changed {
+			t.Fatal("change expected")
+		}
+		if m.Current().Name() != "TimedOut" {
+			t.Fatalf("expected TimedOut, got %s", m.Current().Name())
+		}
+	})
+
+	t.Run("actions receive the firing time", func(t *testing.T) {
+		clock := newManualClock()
+		errAction := errors.New("action failed")
+		var got interface{}
+		a := NewState("a")
+		b := NewState("b", OnEnter(func(_ context.Context, v interface{}) error {
+			got = v
+			return errAction
+		}))
+		m := NewMachine(WithClock(clock), WithTransitions(After(a, time.Second).Then(b)))
+		if _, err := m.Tick(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(time.Second)
+		if _, err := m.Tick(ctx); !errors.Is(err, errAction) {
+			t.Fatalf("expected action error, got %v", err)
+		}
+		if got != clock.Now() {
+			t.Fatalf("expected %v, got %v", clock.Now(), got)
+		}
+		if m.Current().Name() != "a" {
+			t.Fatalf("expected a, got %s", m.Current().Name())
+		}
+	})
+}
+
+func TestStartStop(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fires in the background", func(t *testing.T) {
+		clock := newManualClock()
+		m := payment(t, clock)
+		if err := m.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer m.Stop()
+
+		if err := m.Start(ctx); err == nil {
+			t.Fatal("expected already started error")
+		}
+
+		eventually(t, func() bool { return clock.Waiting() > 0 })
+		clock.Advance(15 * time.Minute)
+		eventually(t, func() bool { return m.Current().Name() == "Expired" })
+	})
+
+	t.Run("updates reschedule", func(t *testing.T) {
+		clock := newManualClock()
+		m := payment(t, clock)
+		if err := m.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer m.Stop()
+
+		eventually(t, func() bool { return clock.Waiting() == 1 })
+		clock.Advance(10 * time.Minute)
+		if _, err := m.Update(ctx, "remind"); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, func() bool { return clock.Waiting() == 2 })
+		clock.Advance(10 * time.Minute)
+		if m.Current().Name() != "AwaitingPayment" {
+			t.Fatalf("expected AwaitingPayment, got %s", m.Current().Name())
+		}
+		clock.Advance(5 * time.Minute)
+		eventually(t, func() bool { return m.Current().Name() == "Expired" })
+	})
+
+	t.Run("errors go to the handler", func(t *testing.T) {
+		clock := newManualClock()
+		errAction := errors.New("action failed")
+		errs := make(chan error, 1)
+		a := NewState("a")
+		b := NewState("b", OnEnter(func(context.Context, interface{}) error {
+			return errAction
+		}))
+		m := NewMachine(
+			WithClock(clock),
+			WithTransitions(After(a, time.Second).Then(b), b.When("x", strIs("x")).Then(a)),
+			WithErrorHandler(func(err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			}),
+		)
+		if err := m.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer m.Stop()
+
+		eventually(t, func() bool { return clock.Waiting() > 0 })
+		clock.Advance(time.Second)
+		select {
+		case err := <-errs:
+			if !errors.Is(err, errAction) {
+				t.Fatalf("expected action error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		m := payment(t, newManualClock())
+		m.Stop()
+		if err := m.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		m.Stop()
+		if err := m.Start(ctx); err != nil {
+			t.Fatalf("expected restart, got %v", err)
+		}
+		m.Stop()
+	})
+}
@@ -0,0 +1,243 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// Guard is a typed trigger function.  It receives the event passed to
+// Typed.Update and a copy of the machine's extended state.
+type Guard[E, C any] func(ctx context.Context, event E, data C) (bool, error)
+
+// Action is a typed action.  It receives the event and a pointer to the
+// machine's extended state, which it may change.  Changes are only kept if
+// every action of the transition succeeds.
+type Action[E, C any] func(ctx context.Context, event E, data *C) error
+
+// Typed is a machine driven by events of type E, carrying extended state of
+// type C.  Its guards and actions are attached with When, Do, OnEnter and
+// OnExit, which only accept a Guard or Action for the same E and C, so a
+// mismatch fails to compile.  The extended state is kept in the machine's
+// Data under a reserved key, so it is reset, snapshotted and carried into
+// definitions like any other extended state.
+type Typed[E, C any] struct {
+	m *machine
+}
+
+// typedKey is the key the extended state of a typed machine is stored under
+// in its Data
+const typedKey = "fsm.typed"
+
+// NewTyped returns a typed machine built from opts, with data as its initial
+// extended state.  Reset, and so Accepts, return the machine to it.
+func NewTyped[E, C any](data C, opts ...Option) *Typed[E, C] {
+	m := NewMachine(opts...)
+	if m.initial == nil {
+		m.initial = Data{}
+	}
+	m.initial[typedKey] = data
+	m.typed = convert[C]
+
+	return &Typed[E, C]{m: m}
+}
+
+// convert returns v as a C.  Values decoded from a serialized snapshot are
+// converted by encoding them as JSON and decoding them into a C.
+func convert[C any](v interface{}) (interface{}, error) {
+	if c, ok := v.(C); ok {
+		return c, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var c C
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// When returns a transition out of from that is taken when g accepts the
+// event.  Values that are not of type E, which only reach g through an
+// instance of a definition built from the machine, never match.
+func (t *Typed[E, C]) When(from State, desc string, g Guard[E, C]) Transition {
+	return from.When(desc, func(ctx context.Context, v interface{}) (bool, error) {
+		event, ok := v.(E)
+		if !ok {
+			return false, nil
+		}
+		data, _ := GetData(ctx, typedKey).(C)
+		return g(ctx, event, data)
+	})
+}
+
+// Do adds a to the actions of tr.  See the package-level Do.
+func (t *Typed[E, C]) Do(tr Transition, a Action[E, C]) Transition {
+	return Do(tr, t.action(a))
+}
+
+// OnEnter adds an action that runs whenever the machine enters the state.
+// See the package-level OnEnter.
+func (t *Typed[E, C]) OnEnter(a Action[E, C]) StateOption {
+	return OnEnter(t.action(a))
+}
+
+// OnExit adds an action that runs whenever the machine leaves the state.
+// See the package-level OnExit.
+func (t *Typed[E, C]) OnExit(a Action[E, C]) StateOption {
+	return OnExit(t.action(a))
+}
+
+// action adapts a to an ActionFunc.  Values that are not of type E, such as
+// the time passed to the actions of timed transitions, are replaced with the
+// zero value of E.  Changes a makes to the extended state are stored with
+// SetData.
+func (t *Typed[E, C]) action(a Action[E, C]) ActionFunc {
+	return func(ctx context.Context, v interface{}) error {
+		event, _ := v.(E)
+		data, _ := GetData(ctx, typedKey).(C)
+		if err := a(ctx, event, &data); err != nil {
+			return err
+		}
+		SetData(ctx, typedKey, data)
+		return nil
+	}
+}
+
+// Data returns the machine's extended state
+func (t *Typed[E, C]) Data() C {
+	t.m.mu.RLock()
+	defer t.m.mu.RUnlock()
+
+	data, _ := t.m.extendedState()[typedKey].(C)
+	return data
+}
+
+// events adapts a sequence of events to a Seq
+func events[E any](seq func(yield func(E) bool)) Seq {
+	return func(yield func(interface{}) bool) {
+		seq(func(e E) bool {
+			return yield(e)
+		})
+	}
+}
+
+// Update evaluates the transitions out of the current state against event.
+// See the machine's Update.
+func (t *Typed[E, C]) Update(ctx context.Context, event E) (bool, error) {
+	return t.m.Update(ctx, event)
+}
+
+func (t *Typed[E, C]) Step(ctx context.Context, event E) (Result, error) {
+	return t.m.Step(ctx, event)
+}
+
+// Run updates the machine with every event of seq; with Go 1.23 or later,
+// seq may be an iter.Seq[E].  See the machine's Run.
+func (t *Typed[E, C]) Run(ctx context.Context, seq func(yield func(E) bool)) (int, error) {
+	return t.m.Run(ctx, events(seq))
+}
+
+// Accepts reports whether the machine accepts the events of seq, starting
+// from its initial state.  See the machine's Accepts.
+func (t *Typed[E, C]) Accepts(ctx context.Context, seq func(yield func(E) bool)) (bool, error) {
+	return t.m.Accepts(ctx, events(seq))
+}
+
+func (t *Typed[E, C]) AddTransition(tr Transition) {
+	t.m.AddTransition(tr)
+}
+
+func (t *Typed[E, C]) AddState(s State) {
+	t.m.AddState(s)
+}
+
+func (t *Typed[E, C]) AddSubstates(parent State, children ...State) {
+	t.m.AddSubstates(parent, children...)
+}
+
+func (t *Typed[E, C]) AddRegion(name string, start State) {
+	t.m.AddRegion(name, start)
+}
+
+func (t *Typed[E, C]) SetStart(name string) error {
+	return t.m.SetStart(name)
+}
+
+func (t *Typed[E, C]) SetEndStates(names ...string) error {
+	return t.m.SetEndStates(names...)
+}
+
+func (t *Typed[E, C]) Validate() error {
+	return t.m.Validate()
+}
+
+func (t *Typed[E, C]) Analyze() Report {
+	return t.m.Analyze()
+}
+
+func (t *Typed[E, C]) Define() (*Definition, error) {
+	return t.m.Define()
+}
+
+func (t *Typed[E, C]) Tick(ctx context.Context) (bool, error) {
+	return t.m.Tick(ctx)
+}
+
+func (t *Typed[E, C]) Start(ctx context.Context) error {
+	return t.m.Start(ctx)
+}
+
+func (t *Typed[E, C]) Stop() {
+	t.m.Stop()
+}
+
+func (t *Typed[E, C]) Reset() error {
+	return t.m.Reset()
+}
+
+func (t *Typed[E, C]) Current() State {
+	return t.m.Current()
+}
+
+func (t *Typed[E, C]) CurrentPath() []State {
+	return t.m.CurrentPath()
+}
+
+func (t *Typed[E, C]) CurrentStates() []State {
+	return t.m.CurrentStates()
+}
+
+func (t *Typed[E, C]) IsEndState() bool {
+	return t.m.IsEndState()
+}
+
+func (t *Typed[E, C]) Snapshot() Snapshot {
+	return t.m.Snapshot()
+}
+
+func (t *Typed[E, C]) Restore(snap Snapshot) error {
+	return t.m.Restore(snap)
+}
+
+func (t *Typed[E, C]) Subscribe(f Observer) func() {
+	return t.m.Subscribe(f)
+}
+
+func (t *Typed[E, C]) Events(size int) (<-chan TransitionEvent, func()) {
+	return t.m.Events(size)
+}
+
+func (t *Typed[E, C]) Graph(w io.Writer) error {
+	return t.m.Graph(w)
+}
+
+func (t *Typed[E, C]) Mermaid(w io.Writer) error {
+	return t.m.Mermaid(w)
+}
+
+func (t *Typed[E, C]) PlantUML(w io.Writer) error {
+	return t.m.PlantUML(w)
+}
@@ -0,0 +1,248 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type payments struct {
+	Retries int
+	Total   int
+}
+
+func event(want string) Guard[string, payments] {
+	return func(_ context.Context, e string, _ payments) (bool, error) {
+		return e == want, nil
+	}
+}
+
+// eventSeq returns a sequence of events
+func eventSeq(events ...string) func(yield func(string) bool) {
+	return func(yield func(string) bool) {
+		for _, e := range events {
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// retrying charges a card, retrying twice before giving up
+func retrying(t *testing.T, opts ...Option) *Typed[string, payments] {
+	t.Helper()
+
+	var (
+		idle     = NewState("idle")
+		charging = NewState("charging")
+		failed   = NewState("failed")
+		charged  = NewState("charged")
+	)
+	m := NewTyped[string](payments{}, opts...)
+	retry := func(_ context.Context, e string, d payments) (bool, error) {
+		return e == "fail" && d.Retries < 2, nil
+	}
+	count := func(_ context.Context, _ string, d *payments) error {
+		d.Retries++
+		return nil
+	}
+	m.AddTransition(m.When(idle, "charge", event("charge")).Then(charging))
+	m.AddTransition(m.Do(m.When(charging, "retry", retry), count).Then(charging))
+	m.AddTransition(m.When(charging, "give up", event("fail")).Then(failed))
+	m.AddTransition(m.Do(m.When(charging, "ok", event("ok")), func(_ context.Context, _ string, d *payments) error {
+		d.Total += 100
+		return nil
+	}).Then(charged))
+	if err := m.SetEndStates("failed", "charged"); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestTyped(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("guards see extended state", func(t *testing.T) {
+		m := retrying(t)
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			event   string
+			state   string
+			retries int
+		}{
+			{"charge", "charging", 0},
+			{"fail", "charging", 1},
+			{"fail", "charging", 2},
+			{"fail", "failed", 2},
+		} {
+			if _, err := m.Update(ctx, tc.event); err != nil {
+				t.Fatal(err)
+			}
+			if m.Current().Name() != tc.state {
+				t.Fatalf("%s: expected %s, got %s", tc.event, tc.state, m.Current().Name())
+			}
+			if got := m.Data().Retries; got != tc.retries {
+				t.Fatalf("%s: expected %d retries, got %d", tc.event, tc.retries, got)
+			}
+		}
+	})
+
+	t.Run("reset restores the initial extended state", func(t *testing.T) {
+		m := retrying(t)
+		for _, e := range []string{"charge", "fail"} {
+			if _, err := m.Update(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Data(); got != (payments{}) {
+			t.Fatalf("unexpected data after reset: %+v", got)
+		}
+
+		// each run starts from the initial extended state, so every run has
+		// both retries left
+		for i := 0; i < 3; i++ {
+			ok, err := m.Accepts(ctx, eventSeq("charge", "fail", "fail", "ok"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok || m.Current().Name() != "charged" || m.Data() != (payments{Retries: 2, Total: 100}) {
+				t.Fatalf("run %d: ended in %s with %+v", i, m.Current().Name(), m.Data())
+			}
+		}
+	})
+
+	t.Run("snapshots", func(t *testing.T) {
+		m := retrying(t)
+		for _, e := range []string{"charge", "fail"} {
+			if _, err := m.Update(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := json.Marshal(m.Snapshot())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			t.Fatal(err)
+		}
+
+		restored := retrying(t)
+		if err := restored.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+		if got := restored.Data(); got != (payments{Retries: 1}) {
+			t.Fatalf("unexpected data: %+v", got)
+		}
+		if _, err := restored.Update(ctx, "fail"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := restored.Update(ctx, "fail"); err != nil {
+			t.Fatal(err)
+		}
+		if got := restored.Current().Name(); got != "failed" {
+			t.Fatalf("expected failed, got %s", got)
+		}
+
+		snap.Data[typedKey] = "not payments"
+		if err := restored.Restore(snap); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("definitions", func(t *testing.T) {
+		def, err := retrying(t).Define()
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := def.NewInstance()
+		// the retries are counted, so the third failure gives up
+		for _, e := range []string{"charge", "fail", "fail", "fail"} {
+			if _, err := i.Update(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := i.Current().Name(); got != "failed" {
+			t.Fatalf("expected failed, got %s", got)
+		}
+		if got := i.ExtendedState()[typedKey]; got != (payments{Retries: 2}) {
+			t.Fatalf("unexpected data: %+v", got)
+		}
+	})
+
+	t.Run("other event types never match", func(t *testing.T) {
+		m := retrying(t)
+		if changed, err := m.m.Update(ctx, 42); changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+	})
+
+	t.Run("failed actions roll back extended state", func(t *testing.T) {
+		errAction := errors.New("action failed")
+		a := NewState("a")
+		b := NewState("b", OnEnter(func(context.Context, interface{}) error {
+			return errAction
+		}))
+		m := NewTyped[string](payments{Total: 1})
+		m.AddTransition(m.Do(m.When(a, "x", event("x")), func(_ context.Context, _ string, d *payments) error {
+			d.Total = 2
+			return nil
+		}).Then(b))
+
+		if _, err := m.Update(ctx, "x"); !errors.Is(err, errAction) {
+			t.Fatalf("expected action error, got %v", err)
+		}
+		if m.Data().Total != 1 {
+			t.Fatalf("expected total 1, got %d", m.Data().Total)
+		}
+	})
+
+	t.Run("state actions", func(t *testing.T) {
+		m := NewTyped[string](payments{})
+		count := func(_ context.Context, _ string, d *payments) error {
+			d.Retries++
+			return nil
+		}
+		a := NewState("a", m.OnExit(count))
+		b := NewState("b", m.OnEnter(count))
+		m.AddTransition(m.When(a, "x", event("x")).Then(b))
+		if _, err := m.Update(ctx, "x"); err != nil {
+			t.Fatal(err)
+		}
+		if m.Data().Retries != 2 {
+			t.Fatalf("expected 2 retries, got %d", m.Data().Retries)
+		}
+	})
+
+	t.Run("timed transitions", func(t *testing.T) {
+		clock := newManualClock()
+		var got string
+		a := NewState("a")
+		b := NewState("b")
+		m := NewTyped[string](payments{}, WithClock(clock))
+		m.AddTransition(m.Do(After(a, time.Second), func(_ context.Context, e string, d *payments) error {
+			got = e
+			d.Retries = 5
+			return nil
+		}).Then(b))
+		if _, err := m.Tick(ctx); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+		if changed, err := m.Tick(ctx); !changed || err != nil {
+			t.Fatalf("changed=%v err=%v", changed, err)
+		}
+		if got != "" || m.Data().Retries != 5 {
+			t.Fatalf("unexpected event %q and data %+v", got, m.Data())
+		}
+	})
+}
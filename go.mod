@@ -1,5 +1,8 @@
 module github.com/schigh/state
 
-go 1.15
+go 1.18
 
-require github.com/schigh/slice v1.0.1
+require (
+	github.com/schigh/slice v1.0.1
+	gopkg.in/yaml.v3 v3.0.1
+)